@@ -0,0 +1,99 @@
+package spa
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// a [http.ResponseWriter] that records what was written, for the access log
+// and onServe callback
+type accessLogWriter struct {
+	http.ResponseWriter
+	// status written (0 until it is)
+	status int
+	// bytes of body written
+	bytes int64
+}
+
+// Implements [http.ResponseWriter]
+func (aw *accessLogWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+// Implements [http.ResponseWriter]
+func (aw *accessLogWriter) Write(bs []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(bs)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+// returns the wrapped writer, for [http.ResponseController]
+func (aw *accessLogWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// ServeEvent describes a request the handler has served (see [WithOnServe])
+type ServeEvent struct {
+	// the request's method and (unaltered) URL path
+	Method string
+	Path   string
+	// status of the response
+	Status int
+	// bytes of body written (which may fall short of the content if the
+	// client went away)
+	Bytes int64
+	// content coding of the body (identity, gzip, or br)
+	Encoding string
+	// how long serving the request took
+	Duration time.Duration
+}
+
+// reports the response to r (written through aw, starting at start) to the
+// access log and onServe callback, as configured
+func (h *Handler) served(r *http.Request, aw *accessLogWriter, start time.Time) {
+	status := aw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	encoding := aw.Header().Get("Content-Encoding")
+	if encoding == "" {
+		encoding = encodingIdentity
+	}
+
+	ev := ServeEvent{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   status,
+		Bytes:    aw.bytes,
+		Encoding: encoding,
+		Duration: time.Since(start),
+	}
+
+	if h.accessLog {
+		h.logAccess(r, ev)
+	}
+
+	if h.config.onServe != nil {
+		h.config.onServe(ev)
+	}
+}
+
+// logs the response described by ev to r
+func (h *Handler) logAccess(r *http.Request, ev ServeEvent) {
+	h.logger.LogAttrs(r.Context(), h.accessLogLevel, "spa: request",
+		slog.String("method", ev.Method),
+		slog.String("path", ev.Path),
+		slog.Int("status", ev.Status),
+		slog.Int64("bytes", ev.Bytes),
+		slog.Duration("duration", ev.Duration),
+		slog.String("encoding", ev.Encoding),
+	)
+}
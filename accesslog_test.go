@@ -0,0 +1,135 @@
+package spa
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	rec := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithLogger(slog.New(rec)), WithAccessLog(slog.LevelWarn))
+
+	wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", "gzip")
+
+	record, attrs, ok := rec.find("spa: request")
+	if !ok {
+		t.Fatal("got no access log record")
+	}
+	if record.Level != slog.LevelWarn {
+		t.Errorf("logged at %v, want %v", record.Level, slog.LevelWarn)
+	}
+
+	for key, want := range map[string]string{
+		"method":   http.MethodGet,
+		"path":     "/index.html",
+		"status":   "200",
+		"bytes":    slog.IntValue(wr.Body.Len()).String(),
+		"encoding": "gzip",
+	} {
+		if got := attrs[key].String(); got != want {
+			t.Errorf("got %s %q, want %q", key, got, want)
+		}
+	}
+	if attrs["duration"].Kind() != slog.KindDuration {
+		t.Errorf("got duration %v, want a duration", attrs["duration"])
+	}
+
+	// one record per request
+	rec.records = nil
+	serve(h, http.MethodGet, "/missing.js", "Accept", "*/*")
+	requests := 0
+	for _, r := range rec.records {
+		if r.Message == "spa: request" {
+			requests++
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("got %d access log records for a request, want 1", requests)
+	}
+	if _, attrs, _ := rec.find("spa: request"); attrs["status"].String() != "404" || attrs["encoding"].String() != "identity" {
+		t.Errorf("404: got status %v with encoding %v", attrs["status"], attrs["encoding"])
+	}
+}
+
+func TestOnServe(t *testing.T) {
+	var events []ServeEvent
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithOnServe(func(ev ServeEvent) {
+		events = append(events, ev)
+	}))
+
+	// the event for a request to h, failing t unless there's exactly one
+	event := func(wr http.ResponseWriter, r *http.Request) ServeEvent {
+		t.Helper()
+
+		events = nil
+		h.ServeHTTP(wr, r)
+		if len(events) != 1 {
+			t.Fatalf("%s %s: got %d events, want 1", r.Method, r.URL.Path, len(events))
+		}
+		return events[0]
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	wr := httptest.NewRecorder()
+	ev := event(wr, r)
+	if ev.Duration <= 0 {
+		t.Errorf("gzip: got duration %v", ev.Duration)
+	}
+	ev.Duration = 0
+	// the bytes sent, rather than the size of the content
+	if want := (ServeEvent{Method: http.MethodGet, Path: "/index.html", Status: http.StatusOK, Bytes: int64(wr.Body.Len()), Encoding: "gzip"}); ev != want || ev.Bytes >= int64(len(compressible)) {
+		t.Errorf("gzip: got %+v, want %+v", ev, want)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/missing.js", nil)
+	r.Header.Set("Accept", "*/*")
+	ev = event(httptest.NewRecorder(), r)
+	ev.Duration = 0
+	if want := (ServeEvent{Method: http.MethodGet, Path: "/missing.js", Status: http.StatusNotFound, Encoding: "identity"}); ev != want {
+		t.Errorf("404: got %+v, want %+v", ev, want)
+	}
+
+	// and however else a request ends
+	for _, tt := range []struct {
+		name   string
+		method string
+		wr     http.ResponseWriter
+		status int
+	}{
+		{"client gone", http.MethodGet, &failingWriter{ResponseRecorder: httptest.NewRecorder(), err: errors.New("broken pipe")}, http.StatusOK},
+		{"method not allowed", http.MethodPost, httptest.NewRecorder(), http.StatusMethodNotAllowed},
+		{"head", http.MethodHead, httptest.NewRecorder(), http.StatusOK},
+	} {
+		r := httptest.NewRequest(tt.method, "/index.html", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if ev := event(tt.wr, r); ev.Status != tt.status || ev.Bytes != 0 {
+			t.Errorf("%s: got status %d with %d bytes, want %d with none", tt.name, ev.Status, ev.Bytes, tt.status)
+		}
+	}
+}
+
+// http.ResponseController (and so flushing) reaches through every writer the
+// handler wraps the response in
+func TestWrappedWritersUnwrap(t *testing.T) {
+	for name, wrap := range map[string]func(http.ResponseWriter) http.ResponseWriter{
+		"access log": func(wr http.ResponseWriter) http.ResponseWriter { return &accessLogWriter{ResponseWriter: wr} },
+		"status": func(wr http.ResponseWriter) http.ResponseWriter {
+			return &statusWriter{ResponseWriter: wr, status: http.StatusNotFound}
+		},
+		"no store": func(wr http.ResponseWriter) http.ResponseWriter { return &noStoreWriter{ResponseWriter: wr} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := http.NewResponseController(wrap(rec)).Flush(); err != nil {
+				t.Fatalf("got %v flushing, want nil", err)
+			}
+			if !rec.Flushed {
+				t.Error("the underlying writer wasn't flushed")
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package spa
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only view of the files h currently serves, e.g. for use
+// with [http.FileServerFS] or html/template's ParseFS. Files contain their
+// uncompressed content; directories are synthesized from the files' paths.
+//
+// The view is a snapshot: it is unaffected by later calls to [Handler.Reload].
+func (h *Handler) FS() fs.FS {
+	// keyed by the files' own paths, whatever the handler's cache is keyed by
+	entries := h.entries()
+	cache := make(map[string]cacheEntry, len(entries))
+	for _, entry := range entries {
+		cache[entry.urlpath] = entry
+	}
+
+	dirs := map[string][]fs.DirEntry{".": nil}
+	for urlpath, entry := range cache {
+		name := strings.TrimPrefix(urlpath, "/")
+		addToDir(dirs, name, cacheFileInfo{name: path.Base(name), entry: entry})
+	}
+
+	for _, children := range dirs {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name() < children[j].Name()
+		})
+	}
+
+	return cacheFS{cache: cache, dirs: dirs}
+}
+
+// adds info (the file or directory at name) to its parent in dirs,
+// adding any missing ancestors along the way
+func addToDir(dirs map[string][]fs.DirEntry, name string, info cacheFileInfo) {
+	parent := path.Dir(name)
+	if _, ok := dirs[parent]; !ok {
+		addToDir(dirs, parent, cacheFileInfo{name: path.Base(parent), dir: true})
+		dirs[parent] = nil
+	}
+
+	dirs[parent] = append(dirs[parent], fs.FileInfoToDirEntry(info))
+}
+
+// an [fs.FS] over a handler's cache
+type cacheFS struct {
+	cache map[string]cacheEntry
+	// entries in each directory, keyed by fs path ("." for the root)
+	dirs map[string][]fs.DirEntry
+}
+
+func (cfs cacheFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if children, ok := cfs.dirs[name]; ok {
+		return &cacheDir{info: cacheFileInfo{name: path.Base(name), dir: true}, children: children}, nil
+	}
+
+	entry, ok := cfs.cache["/"+name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if entry.streamed || entry.lazy != nil {
+		return entry.source.Open(entry.fpath)
+	}
+
+	return &cacheFile{
+		Reader: bytes.NewReader(entry.identity),
+		info:   cacheFileInfo{name: path.Base(name), entry: entry},
+	}, nil
+}
+
+// a file (or directory) in a cacheFS, implementing [fs.FileInfo]
+type cacheFileInfo struct {
+	name  string
+	dir   bool
+	entry cacheEntry
+}
+
+func (fi cacheFileInfo) Name() string       { return fi.name }
+func (fi cacheFileInfo) Size() int64        { return int64(fi.entry.identitySize) }
+func (fi cacheFileInfo) ModTime() time.Time { return fi.entry.modTime }
+func (fi cacheFileInfo) IsDir() bool        { return fi.dir }
+func (fi cacheFileInfo) Sys() any           { return nil }
+func (fi cacheFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// an open file in a cacheFS
+type cacheFile struct {
+	*bytes.Reader
+	info cacheFileInfo
+}
+
+func (f *cacheFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *cacheFile) Close() error               { return nil }
+
+// an open directory in a cacheFS
+type cacheDir struct {
+	info     cacheFileInfo
+	children []fs.DirEntry
+	// number of children already returned by ReadDir
+	offset int
+}
+
+func (d *cacheDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *cacheDir) Close() error               { return nil }
+
+func (d *cacheDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements [fs.ReadDirFile]
+func (d *cacheDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.children[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.children)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
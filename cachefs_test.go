@@ -0,0 +1,53 @@
+package spa
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	files := map[string]string{
+		"index.html":          compressible,
+		"app.js":              "console.log(1)",
+		"assets/css/site.css": "body{}",
+		"assets/logo.svg":     "<svg></svg>",
+	}
+	h := newTestHandler(t, files)
+	fsys := h.FS()
+
+	bs, err := fs.ReadFile(fsys, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != compressible {
+		t.Errorf("index.html: got %d bytes, want the uncompressed %d", len(bs), len(compressible))
+	}
+
+	fi, err := fs.Stat(fsys, "assets/logo.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := h.entries()["/assets/logo.svg"]; fi.Size() != int64(len(files["assets/logo.svg"])) || !fi.ModTime().Equal(entry.modTime) {
+		t.Errorf("assets/logo.svg: got size %d and mtime %v, want %d and %v", fi.Size(), fi.ModTime(), len(files["assets/logo.svg"]), entry.modTime)
+	}
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(fpath string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			walked = append(walked, fpath)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"app.js", "assets/css/site.css", "assets/logo.svg", "index.html"}; !slices.Equal(walked, want) {
+		t.Errorf("walked %q, want %q", walked, want)
+	}
+
+	if err := fstest.TestFS(fsys, walked...); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,484 @@
+package spa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+)
+
+// the compressed forms of some content
+type compressedVariants struct {
+	gzipped []byte
+	brotli  []byte
+}
+
+// compresses the in-memory entries worth compressing, concurrently across
+// workers goroutines. The first error (or ctx being done) aborts the
+// remaining work. The result doesn't depend on the number of workers.
+//
+// Identical content (i.e. entries with the same etag) is only compressed once,
+// and its entries share the compressed buffers.
+func compressEntries(ctx context.Context, entries []cacheEntry, c *config, workers int) error {
+	if !c.compression {
+		return nil
+	}
+
+	var unique []int
+	seen := make(map[string]bool, len(entries))
+	for i, ce := range entries {
+		if seen[ce.etag] || !ce.worthCompressing(c) {
+			continue
+		}
+
+		seen[ce.etag] = true
+		unique = append(unique, i)
+	}
+
+	if c.compressionBudget > 0 {
+		// spend the budget where it goes furthest - text compresses well,
+		// and small files leave room for more of them
+		sort.SliceStable(unique, func(a, b int) bool {
+			ea, eb := &entries[unique[a]], &entries[unique[b]]
+			if ta, tb := isTextual(mediaTypeOf(ea.contentType)), isTextual(mediaTypeOf(eb.contentType)); ta != tb {
+				return ta
+			}
+			return ea.identitySize < eb.identitySize
+		})
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		budget   = newBudgetTracker(c.compressionBudget, len(unique))
+	)
+
+	results := make([]compressedVariants, len(unique))
+	work := make(chan int)
+	abort := make(chan struct{})
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cp := &compressor{gzipLevel: c.gzipLevel, brotli: c.brotli, brotliLevel: c.brotliLevel}
+			for j := range work {
+				ce := &entries[unique[j]]
+
+				// no point compressing what there's no budget left to keep
+				if budget.spent() {
+					continue
+				}
+
+				var err error
+				results[j], err = cp.compress(ce.identity)
+				if err == nil {
+					if c.verifyCompression {
+						results[j] = verifiedVariants(ce, results[j], c)
+					}
+					budget.finish(j, retainedSize(ce.identitySize, results[j], c))
+					continue
+				}
+
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("spa: error compressing %s: %w", ce.urlpath, err)
+					close(abort)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for j := range unique {
+		select {
+		case work <- j:
+		case <-abort:
+			break feed
+		case <-ctx.Done():
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			mu.Unlock()
+			break feed
+		}
+	}
+
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	byETag := make(map[string]compressedVariants, len(unique))
+	var retained int64
+	for j, i := range unique {
+		if c.compressionBudget > 0 && retained >= c.compressionBudget {
+			c.logger.Debug("spa: compression budget exhausted", "budget", c.compressionBudget, "uncompressed", len(unique)-j)
+			break
+		}
+
+		byETag[entries[i].etag] = results[j]
+		retained += int64(retainedSize(entries[i].identitySize, results[j], c))
+	}
+
+	for i := range entries {
+		// the same content may also be served under an already compressed type
+		if !entries[i].worthCompressing(c) {
+			continue
+		}
+
+		if v, ok := byETag[entries[i].etag]; ok {
+			attachVariants(&entries[i], v, c)
+		}
+	}
+
+	return nil
+}
+
+// tracks the compressed bytes retained by the work done so far, so that
+// workers can stop early once the budget (0 for none) is spent.
+//
+// Only the work done in order counts: whichever order the workers finish in,
+// it is spent just when the first files' variants (which are kept in order,
+// up to the budget) fill it - so stopping then never changes what is kept.
+type budgetTracker struct {
+	budget int64
+
+	mu sync.Mutex
+	// bytes retained by each piece of work, once it is done
+	sizes    []int
+	finished []bool
+	// number of pieces done in order (i.e. from the first up to the first
+	// not done yet), and the bytes they retain
+	done     int
+	retained int64
+	// set once retained reaches the budget
+	full atomic.Bool
+}
+
+func newBudgetTracker(budget int64, n int) *budgetTracker {
+	return &budgetTracker{budget: budget, sizes: make([]int, n), finished: make([]bool, n)}
+}
+
+// Reports whether the work done in order has spent the budget, so that no
+// later work would be kept
+func (bt *budgetTracker) spent() bool {
+	return bt.full.Load()
+}
+
+// records that piece j of the work is done, retaining size bytes
+func (bt *budgetTracker) finish(j int, size int) {
+	if bt.budget <= 0 {
+		return
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	bt.sizes[j], bt.finished[j] = size, true
+	for bt.done < len(bt.finished) && bt.finished[bt.done] {
+		bt.retained += int64(bt.sizes[bt.done])
+		bt.done++
+	}
+
+	if bt.retained >= bt.budget {
+		bt.full.Store(true)
+	}
+}
+
+// Reports whether ce's content is worth compressing in process
+func (ce cacheEntry) worthCompressing(c *config) bool {
+	// tiny files aren't worth the CPU - and can even grow when compressed
+	// (as empty ones always do, whatever the minimum size)
+	if ce.streamed || ce.lazy != nil || ce.identitySize == 0 || ce.identitySize < c.minCompressSize {
+		return false
+	}
+
+	// neither are formats that are compressed already (e.g. PNGs) - or, given
+	// an allowlist of compressible types, any not on it
+	compressible := !contentTypeIsAlreadyCompressed(ce.contentType, c.compressedTypes)
+	if len(c.compressibleTypes) > 0 {
+		compressible = matchesContentType(mediaTypeOf(ce.contentType), c.compressibleTypes)
+	}
+	if !compressible {
+		return false
+	}
+
+	// precompressed sidecars take the place of compressing in process
+	return ce.gzipHandler == nil || (c.brotli && ce.brotliHandler == nil)
+}
+
+// compresses content, reusing its writers and scratch buffer from one call to
+// the next - so that compressing many files doesn't allocate them for each.
+// It is not safe for concurrent use.
+type compressor struct {
+	gzipLevel int
+	// false to skip brotli, leaving only gzip
+	brotli      bool
+	brotliLevel int
+
+	buf bytes.Buffer
+	// created on first use
+	gz *gzip.Writer
+	br *brotli.Writer
+}
+
+// a compressing writer that can be reused
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(wr io.Writer)
+}
+
+// returns the compressed forms of bs
+func (cp *compressor) compress(bs []byte) (compressedVariants, error) {
+	if cp.gz == nil {
+		gz, err := gzip.NewWriterLevel(&cp.buf, cp.gzipLevel)
+		if err != nil {
+			return compressedVariants{}, fmt.Errorf("gzip: error creating compressor: %w", err)
+		}
+
+		cp.gz = gz
+		if cp.brotli {
+			cp.br = brotli.NewWriterLevel(&cp.buf, cp.brotliLevel)
+		}
+	}
+
+	gbs, err := cp.run(bs, cp.gz)
+	if err != nil {
+		return compressedVariants{}, fmt.Errorf("gzip: %w", err)
+	}
+
+	if cp.br == nil {
+		return compressedVariants{gzipped: gbs}, nil
+	}
+
+	bbs, err := cp.run(bs, cp.br)
+	if err != nil {
+		return compressedVariants{}, fmt.Errorf("brotli: %w", err)
+	}
+
+	return compressedVariants{gzipped: gbs, brotli: bbs}, nil
+}
+
+// returns bs compressed by wr
+func (cp *compressor) run(bs []byte, wr resettableWriter) ([]byte, error) {
+	cp.buf.Reset()
+	wr.Reset(&cp.buf)
+
+	_, err := wr.Write(bs)
+	if err != nil {
+		wr.Close()
+		return nil, fmt.Errorf("error writing compressed content: %w", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error flushing compressed content: %w", err)
+	}
+
+	// buf is reused for the next run, so the result is copied out of it -
+	// which also leaves the cache holding no spare capacity
+	return bytes.Clone(cp.buf.Bytes()), nil
+}
+
+// returns v if each of its variants decompresses back to ce's content -
+// otherwise, none of them are to be served, and the error is logged
+func verifiedVariants(ce *cacheEntry, v compressedVariants, c *config) compressedVariants {
+	err := verifyCompressed(ce.identity, v.gzipped, func(rd io.Reader) (io.Reader, error) {
+		return gzip.NewReader(rd)
+	})
+	if err == nil {
+		err = verifyCompressed(ce.identity, v.brotli, func(rd io.Reader) (io.Reader, error) {
+			return brotli.NewReader(rd), nil
+		})
+	}
+	if err != nil {
+		c.logger.Error("spa: compressed content failed verification, serving it uncompressed", "path", ce.urlpath, "err", err)
+		return compressedVariants{}
+	}
+
+	return v
+}
+
+// returns an error unless compressed decompresses (with a reader from newReader) to bs
+func verifyCompressed(bs []byte, compressed []byte, newReader func(rd io.Reader) (io.Reader, error)) error {
+	rd, err := newReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("error creating decompressor: %w", err)
+	}
+
+	decompressed, err := io.ReadAll(rd)
+	if err != nil {
+		return fmt.Errorf("error decompressing content: %w", err)
+	}
+
+	if !bytes.Equal(decompressed, bs) {
+		return errors.New("decompressed content differs from the original")
+	}
+
+	return nil
+}
+
+// returns the bytes of v that are worth keeping for content of identitySize bytes
+func retainedSize(identitySize int, v compressedVariants, c *config) int {
+	size := 0
+	for _, bs := range [][]byte{v.gzipped, v.brotli} {
+		if bs != nil && worthServingToSaveData(identitySize, len(bs), c) {
+			size += len(bs)
+		}
+	}
+
+	return size
+}
+
+// attaches the compressed variants of ce's content that are worth serving to ce
+func attachVariants(ce *cacheEntry, v compressedVariants, c *config) {
+	// a precompressed sidecar found for an encoding takes precedence
+	if v.gzipped != nil && ce.gzipHandler == nil {
+		if worthServing(ce.identitySize, len(v.gzipped), c) {
+			ce.compressedSize = len(v.gzipped)
+			ce.gzipHandler = encodedHandler(ce, encodingGzip, v.gzipped, c.logger)
+		} else {
+			attachSaveDataVariant(ce, encodingGzip, v.gzipped, c)
+		}
+	}
+
+	if v.brotli != nil && ce.brotliHandler == nil {
+		if worthServing(ce.identitySize, len(v.brotli), c) {
+			ce.brotliSize = len(v.brotli)
+			ce.brotliHandler = encodedHandler(ce, encodingBrotli, v.brotli, c.logger)
+		} else {
+			attachSaveDataVariant(ce, encodingBrotli, v.brotli, c)
+		}
+	}
+
+	ce.shouldServeCompressed = ce.gzipHandler != nil || ce.brotliHandler != nil
+}
+
+// attaches bs (the content of ce compressed with encoding, which saves too
+// little to be worth serving to everyone) to ce for clients asking to save
+// data, if it is worth serving to them
+func attachSaveDataVariant(ce *cacheEntry, encoding string, bs []byte, c *config) {
+	if !worthServingToSaveData(ce.identitySize, len(bs), c) {
+		return
+	}
+
+	if ce.saveDataVariants == nil {
+		ce.saveDataVariants = make(map[string]saveDataVariant, 2)
+	}
+	ce.saveDataVariants[encoding] = saveDataVariant{size: len(bs), handler: encodedHandler(ce, encoding, bs, c.logger)}
+}
+
+// Reports whether compressed content of compressedSize bytes is worth serving
+// instead of identitySize bytes: it must save at least one TCP packet, and
+// (if configured) meet the minimum compression ratio.
+func worthServing(identitySize int, compressedSize int, c *config) bool {
+	return savesPacket(identitySize, compressedSize) && worthServingToSaveData(identitySize, compressedSize, c)
+}
+
+// Reports whether compressed content of compressedSize bytes is worth serving
+// instead of identitySize bytes to a client asking to save data: any saving
+// will do, so long as it (if configured) meets the minimum compression ratio.
+func worthServingToSaveData(identitySize int, compressedSize int, c *config) bool {
+	if compressedSize >= identitySize {
+		return false
+	}
+
+	return c.minCompressionRatio == 0 || float64(compressedSize) <= c.minCompressionRatio*float64(identitySize)
+}
+
+// Reports whether serving compressedSize bytes instead of identitySize bytes
+// saves at least one TCP packet.
+func savesPacket(identitySize int, compressedSize int) bool {
+	return (identitySize / tcpPacketDataSize) > (compressedSize / tcpPacketDataSize)
+}
+
+// returns a handler that serves bs (the content of ce compressed with encoding)
+func encodedHandler(ce *cacheEntry, encoding string, bs []byte, logger *slog.Logger) func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Content-Type", ce.contentType)
+		wr.Header().Set("Content-Encoding", encoding)
+		wr.Header().Set("Content-Length", strconv.Itoa(len(bs)))
+		// ranges are only served from the identity content (see cacheEntry.serve)
+		wr.Header().Set("Accept-Ranges", "none")
+		wr.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		_, err := io.Copy(wr, bytes.NewReader(bs))
+		if err != nil {
+			logServeError(logger, r, err, "path", ce.urlpath, "encoding", encoding)
+		}
+	}
+}
+
+// Reports whether the content type supports compression as part of its encoding.
+// This can be used to prevent double-compressing content.
+//
+// extra lists additional already-compressed content types; an entry ending in
+// '/' (e.g. "model/") matches the whole family.
+func contentTypeIsAlreadyCompressed(contentType string, extra []string) bool {
+	mediaType := mediaTypeOf(contentType)
+
+	if matchesContentType(mediaType, extra) {
+		return true
+	}
+
+	switch mediaType {
+	case "font/woff", "font/woff2",
+		"application/zip", "application/gzip", "application/x-gzip", "application/x-brotli",
+		"application/zstd", "application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/vnd.rar", "application/x-rar-compressed",
+		"application/ogg", "application/pdf":
+		return true
+
+	// these members of otherwise-compressed families are plain (or text) formats
+	case "image/svg+xml", "image/bmp", "image/tiff", "image/vnd.microsoft.icon", "image/x-icon",
+		"audio/wav", "audio/x-wav", "audio/vnd.wave":
+		return false
+	}
+
+	// image (webp, avif, jpeg, png, ...), audio (mpeg, ogg, ...), and video
+	// (mp4, webm, ...) formats are virtually always compressed by their codecs
+	return strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "audio/") ||
+		strings.HasPrefix(mediaType, "video/")
+}
+
+// Reports whether mediaType is one of types, where a type ending in '/'
+// (e.g. "model/") matches the whole family
+func matchesContentType(mediaType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns contentType without any parameters (e.g. "text/html" for "text/html; charset=utf-8")
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
@@ -0,0 +1,632 @@
+package spa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// returns n bytes that don't compress (being random)
+func incompressible(n int) string {
+	bs := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(bs)
+	return string(bs)
+}
+
+func TestIncompressibleNotServedCompressed(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		// a type that would be compressed, were it worth it
+		"blob.txt": incompressible(4 * tcpPacketDataSize),
+	})
+
+	entry := h.entries()["/blob.txt"]
+	if entry.shouldServeCompressed || entry.gzipHandler != nil || entry.brotliHandler != nil {
+		t.Errorf("incompressible content has compressed variants (%d gzipped, %d brotli)", entry.compressedSize, entry.brotliSize)
+	}
+
+	wr := serve(h, http.MethodGet, "/blob.txt", "Accept-Encoding", "gzip, br")
+	if got := wr.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if wr.Body.Len() != 4*tcpPacketDataSize {
+		t.Errorf("got %d bytes, want %d", wr.Body.Len(), 4*tcpPacketDataSize)
+	}
+}
+
+func TestCompressedTypesNotCompressed(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		// content that compresses well, which only the type rules out
+		"font.woff2":    compressible,
+		"data.spatest2": compressible,
+		"plain.txt":     compressible,
+	}, WithMimeType(".spatest2", "application/x-spa-archive"), WithCompressedTypes("application/x-spa-archive"))
+
+	for _, tt := range []struct {
+		path     string
+		encoding string
+	}{
+		{"/font.woff2", ""},
+		{"/data.spatest2", ""},
+		{"/plain.txt", "gzip"},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept-Encoding", "gzip")
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", tt.path, got, tt.encoding)
+		}
+		if tt.encoding == "" && wr.Body.String() != compressible {
+			t.Errorf("%s: got %d bytes, want the file as is", tt.path, wr.Body.Len())
+		}
+	}
+}
+
+func TestCompressibleTypes(t *testing.T) {
+	// content that compresses well, so that only the type decides
+	files := map[string]string{
+		"index.html": compressible,
+		"app.css":    compressible,
+		"app.js":     compressible,
+		"data.json":  compressible,
+		"logo.svg":   compressible,
+		"photo.png":  compressible,
+		"blob.bin":   compressible,
+	}
+	h := newTestHandler(t, files, WithCompressibleTypes("text/", "application/json", "image/png"))
+
+	for urlpath, want := range map[string]bool{
+		"/index.html": true,
+		"/app.css":    true,
+		"/app.js":     true,
+		"/data.json":  true,
+		// not on the list, though compressed by default
+		"/logo.svg": false,
+		"/blob.bin": false,
+		// on the list, though never compressed by default
+		"/photo.png": true,
+	} {
+		entry := h.entries()[urlpath]
+		if got := entry.gzipHandler != nil; got != want {
+			t.Errorf("%s (%s): has a gzip variant is %v, want %v", urlpath, entry.contentType, got, want)
+		}
+
+		wr := serve(h, http.MethodGet, urlpath, "Accept-Encoding", "gzip")
+		if got := wr.Header().Get("Content-Encoding") == "gzip"; got != want {
+			t.Errorf("%s: served gzipped is %v, want %v", urlpath, got, want)
+		}
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithCompressibleTypes("text/"), WithCompressedTypes("image/png"))
+	if err == nil {
+		t.Error("got no error combining an allowlist and a denylist")
+	}
+}
+
+// returns body decoded from encoding
+func decode(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	rd := body
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = gz
+	case "br":
+		rd = brotli.NewReader(body)
+	}
+
+	bs, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
+func TestServeBrotli(t *testing.T) {
+	files := map[string]string{"index.html": compressible}
+
+	for _, tt := range []struct {
+		name           string
+		opts           []Option
+		acceptEncoding string
+		encoding       string
+	}{
+		{"br", nil, "br", "br"},
+		{"gzip, br", nil, "gzip, br", "br"},
+		{"gzip", nil, "gzip", "gzip"},
+		{"br at its best", []Option{WithBrotliLevel(brotli.BestCompression)}, "gzip, br", "br"},
+		{"br disabled", []Option{WithBrotli(false)}, "gzip, br", "gzip"},
+		{"br only, disabled", []Option{WithBrotli(false)}, "br", ""},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", tt.acceptEncoding)
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != compressible {
+			t.Errorf("%s: got %d bytes of content, want the file", tt.name, len(got))
+		}
+	}
+}
+
+func TestInvalidBrotliLevel(t *testing.T) {
+	dir := writeTree(t, map[string]string{"index.html": compressible})
+
+	for _, level := range []int{-1, 12} {
+		if _, err := NewHandlerWithOptions(dir, WithLogger(discardLogger), WithBrotliLevel(level)); err == nil {
+			t.Errorf("level %d: got no error", level)
+		}
+	}
+}
+
+// returns about n bytes of text, varied enough that how hard it is compressed
+// makes a difference
+func prose(n int) string {
+	words := strings.Fields("the quick brown fox jumps over a lazy dog while seven wizards box nimbly past jovial quartz judges")
+	rnd := rand.New(rand.NewSource(1))
+
+	var sb strings.Builder
+	for sb.Len() < n {
+		sb.WriteString(words[rnd.Intn(len(words))])
+		sb.WriteByte(" \n"[rnd.Intn(2)])
+	}
+
+	return sb.String()
+}
+
+func TestGzipLevel(t *testing.T) {
+	files := map[string]string{"index.html": prose(64 << 10)}
+
+	sizes := make(map[int]int)
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		h := newTestHandler(t, files, WithGzipLevel(level))
+
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", "gzip")
+		if got := decode(t, "gzip", wr.Body); got != files["index.html"] {
+			t.Fatalf("level %d: got %d bytes of content, want the file", level, len(got))
+		}
+		sizes[level] = h.entries()["/index.html"].compressedSize
+	}
+
+	if sizes[gzip.BestCompression] >= sizes[gzip.BestSpeed] {
+		t.Errorf("got %d bytes at BestCompression, want fewer than the %d at BestSpeed", sizes[gzip.BestCompression], sizes[gzip.BestSpeed])
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithGzipLevel(gzip.BestCompression+1))
+	if err == nil {
+		t.Error("got no error for an invalid level")
+	}
+}
+
+func TestMinCompressSize(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"tiny.json":  `{"a":"` + strings.Repeat("b", 90) + `"}`,
+		"big.txt":    strings.Repeat("c", 3000),
+	}
+	h := newTestHandler(t, files, WithMinCompressSize(512))
+
+	if entry := h.entries()["/tiny.json"]; entry.gzipHandler != nil || entry.brotliHandler != nil || entry.compressedSize != -1 {
+		t.Errorf("100 byte file: got a %d byte gzip variant, want none", entry.compressedSize)
+	}
+	if entry := h.entries()["/big.txt"]; entry.gzipHandler == nil {
+		t.Error("3000 byte file: got no gzip variant")
+	}
+
+	wr := serve(h, http.MethodGet, "/tiny.json", "Accept-Encoding", "gzip")
+	if got := wr.Header().Get("Content-Encoding"); got != "" || wr.Body.String() != files["tiny.json"] {
+		t.Errorf("100 byte file: got Content-Encoding %q with %q, want it as is", got, wr.Body.String())
+	}
+}
+
+// returns n in-memory entries of varied sizes and types, as the scan would
+func testEntries(n int) []cacheEntry {
+	entries := make([]cacheEntry, n)
+	for i := range entries {
+		var (
+			urlpath     = "/file" + strconv.Itoa(i)
+			contentType = "text/plain; charset=utf-8"
+			data        = prose(512 << (i % 6))
+		)
+		if i%3 == 0 {
+			contentType = "application/octet-stream"
+			data = incompressible(1 << 10 << (i % 4))
+		}
+
+		entries[i] = buildCacheEntry(urlpath, contentType, []byte(data), time.Time{})
+	}
+
+	return entries
+}
+
+// returns the content ce serves by encoding (and Save-Data), to compare
+// entries by what clients get
+func servedVariants(ce cacheEntry) map[string]string {
+	ret := make(map[string]string)
+	for _, encoding := range []string{encodingGzip, encodingBrotli} {
+		for _, saveData := range []string{"", "on"} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", encoding+", identity;q=0")
+			r.Header.Set("Save-Data", saveData)
+
+			wr := httptest.NewRecorder()
+			ce.ServeHTTP(wr, r)
+			ret[encoding+saveData] = wr.Header().Get("Content-Encoding") + ":" + wr.Body.String()
+		}
+	}
+
+	return ret
+}
+
+func TestCompressEntriesDeterministic(t *testing.T) {
+	for _, budget := range []int64{0, 20 << 10} {
+		c := newConfig([]Option{WithLogger(discardLogger), WithCompressionBudget(budget)})
+
+		serial := testEntries(48)
+		if err := compressEntries(context.Background(), serial, c, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// repeatedly, as the workers may finish in any order
+		for range 10 {
+			parallel := testEntries(48)
+			if err := compressEntries(context.Background(), parallel, c, 8); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range serial {
+				s, p := serial[i], parallel[i]
+				if s.compressedSize != p.compressedSize || s.brotliSize != p.brotliSize || s.shouldServeCompressed != p.shouldServeCompressed {
+					t.Fatalf("budget %d: %s: got %d gzipped and %d brotli in parallel, want %d and %d as serially", budget, s.urlpath, p.compressedSize, p.brotliSize, s.compressedSize, s.brotliSize)
+				}
+				if !maps.Equal(servedVariants(s), servedVariants(p)) {
+					t.Fatalf("budget %d: %s: serves different content in parallel than serially", budget, s.urlpath)
+				}
+			}
+		}
+	}
+}
+
+func TestCompressionBudget(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"a.txt":      prose(4 << 10),
+		"b.txt":      prose(8 << 10),
+		"c.txt":      prose(16 << 10),
+		"d.txt":      prose(32 << 10),
+		// text goes first, so this is never reached
+		"e.bin": prose(2 << 10),
+	}
+
+	// the smallest text file's variants fill it
+	h := newTestHandler(t, files, WithCompressionBudget(1))
+	for urlpath, want := range map[string]bool{"/a.txt": true, "/b.txt": false, "/c.txt": false, "/d.txt": false, "/e.bin": false} {
+		if got := h.entries()[urlpath].shouldServeCompressed; got != want {
+			t.Errorf("tiny budget: %s served compressed is %v, want %v", urlpath, got, want)
+		}
+	}
+
+	// room for the first two, and then some
+	small := newTestHandler(t, files, WithCompressionBudget(1<<30))
+	budget := int64(small.entries()["/a.txt"].compressedSize + small.entries()["/a.txt"].brotliSize + 1)
+	h = newTestHandler(t, files, WithCompressionBudget(budget))
+	for urlpath, want := range map[string]bool{"/a.txt": true, "/b.txt": true, "/c.txt": false, "/d.txt": false} {
+		if got := h.entries()[urlpath].shouldServeCompressed; got != want {
+			t.Errorf("budget %d: %s served compressed is %v, want %v", budget, urlpath, got, want)
+		}
+	}
+}
+
+func BenchmarkCompressEntries(b *testing.B) {
+	c := newConfig([]Option{WithLogger(discardLogger)})
+
+	for _, bm := range []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU()},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				entries := testEntries(48)
+				b.StartTimer()
+
+				if err := compressEntries(context.Background(), entries, c, bm.workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// compares compressing files with a single reused compressor, as the workers
+// do, to using a new one (and so new writers and buffer) for each file
+func BenchmarkCompressor(b *testing.B) {
+	files := make([][]byte, 16)
+	for i := range files {
+		files[i] = []byte(prose(4 << 10 << (i % 4)))
+	}
+
+	for _, bm := range []struct {
+		name  string
+		reuse bool
+	}{
+		{"reused", true},
+		{"fresh", false},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			cp := &compressor{gzipLevel: gzip.DefaultCompression, brotli: true, brotliLevel: defaultBrotliLevel}
+			for range b.N {
+				for _, bs := range files {
+					if !bm.reuse {
+						cp = &compressor{gzipLevel: gzip.DefaultCompression, brotli: true, brotliLevel: defaultBrotliLevel}
+					}
+					if _, err := cp.compress(bs); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*len(files)), "allocs/file")
+			b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(b.N*len(files)), "B/file")
+		})
+	}
+}
+
+func TestCompressorOutputUnchanged(t *testing.T) {
+	cp := &compressor{gzipLevel: gzip.BestCompression, brotli: true, brotliLevel: defaultBrotliLevel}
+
+	// of varying sizes, so that a reused buffer holds more than the next needs
+	for _, data := range []string{prose(64 << 10), "tiny", incompressible(8 << 10), prose(1 << 10), ""} {
+		v, err := cp.compress([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var gz bytes.Buffer
+		gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gw.Write([]byte(data))
+		gw.Close()
+
+		var br bytes.Buffer
+		bw := brotli.NewWriterLevel(&br, defaultBrotliLevel)
+		bw.Write([]byte(data))
+		bw.Close()
+
+		if !bytes.Equal(v.gzipped, gz.Bytes()) {
+			t.Errorf("%d bytes: gzip differs from a new writer's", len(data))
+		}
+		if !bytes.Equal(v.brotli, br.Bytes()) {
+			t.Errorf("%d bytes: brotli differs from a new writer's", len(data))
+		}
+		if decode(t, "gzip", bytes.NewReader(v.gzipped)) != data || decode(t, "br", bytes.NewReader(v.brotli)) != data {
+			t.Errorf("%d bytes: got different content back", len(data))
+		}
+	}
+}
+
+// compares building a handler over PNGs, which are never compressed, with
+// building one over the same content of a type that is
+func BenchmarkNewHandlerPNGs(b *testing.B) {
+	for _, ext := range []string{".png", ".bin"} {
+		files := map[string]string{"index.html": "<html></html>"}
+		for i := range 64 {
+			files["img/"+strconv.Itoa(i)+ext] = incompressible(16 << 10)
+		}
+		dir := writeTree(b, files)
+
+		b.Run(ext, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := NewHandlerWithOptions(dir, WithLogger(discardLogger)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"app.js":     compressible + "//",
+	}, WithCompression(false))
+
+	for urlpath, entry := range h.entries() {
+		if entry.gzipHandler != nil || entry.brotliHandler != nil || len(entry.saveDataVariants) > 0 {
+			t.Errorf("%s: has compressed variants", urlpath)
+		}
+	}
+
+	for _, acceptEncoding := range []string{"gzip", "br", "gzip, br"} {
+		for _, urlpath := range []string{"/index.html", "/app.js", "/some/route"} {
+			wr := serve(h, http.MethodGet, urlpath, "Accept", "text/html", "Accept-Encoding", acceptEncoding, "Save-Data", "on")
+			if got := wr.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("%s (%q): got Content-Encoding %q, want none", urlpath, acceptEncoding, got)
+			}
+		}
+	}
+}
+
+func TestBuildCacheEntry(t *testing.T) {
+	c := newConfig([]Option{WithLogger(discardLogger)})
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		data        string
+		compressed  bool
+	}{
+		{"compressible text", "text/html; charset=utf-8", compressible, true},
+		{"already compressed type", "image/png", compressible, false},
+		{"too small", "text/plain; charset=utf-8", "hello", false},
+		{"empty", "text/plain; charset=utf-8", "", false},
+		{"incompressible", "application/octet-stream", incompressible(8 << 10), false},
+	} {
+		ce := buildCacheEntry("/file", tt.contentType, []byte(tt.data), modTime)
+		if ce.urlpath != "/file" || ce.contentType != tt.contentType || ce.identitySize != len(tt.data) || !ce.modTime.Equal(modTime) {
+			t.Errorf("%s: got %+v", tt.name, ce)
+		}
+		if ce.shouldServeCompressed || ce.compressedSize != -1 || ce.brotliSize != -1 {
+			t.Errorf("%s: compressed before compressEntries", tt.name)
+		}
+
+		entries := []cacheEntry{ce}
+		if err := compressEntries(context.Background(), entries, c, 1); err != nil {
+			t.Fatal(err)
+		}
+		if got := entries[0].shouldServeCompressed; got != tt.compressed {
+			t.Errorf("%s: served compressed is %v, want %v", tt.name, got, tt.compressed)
+		}
+		if tt.compressed && (entries[0].gzipHandler == nil || entries[0].compressedSize >= len(tt.data)) {
+			t.Errorf("%s: got a %d byte gzip variant of %d bytes", tt.name, entries[0].compressedSize, len(tt.data))
+		}
+	}
+
+	// an unknown type is never left out
+	if ce := buildCacheEntry("/file", "", []byte("data"), time.Time{}); ce.contentType != "application/octet-stream" {
+		t.Errorf("got content type %q for an unknown type, want application/octet-stream", ce.contentType)
+	}
+}
+
+func TestVerifyCompression(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithVerifyCompression())
+	if entry := h.entries()["/index.html"]; entry.gzipHandler == nil || entry.brotliHandler == nil {
+		t.Error("verified content isn't served compressed")
+	}
+
+	rec := &recordingHandler{}
+	c := newConfig([]Option{WithLogger(slog.New(rec))})
+	ce := buildCacheEntry("/index.html", "text/html; charset=utf-8", []byte(compressible), time.Time{})
+
+	cp := &compressor{gzipLevel: c.gzipLevel, brotli: c.brotli, brotliLevel: c.brotliLevel}
+	v, err := cp.compress(ce.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := verifiedVariants(&ce, v, c); got.gzipped == nil || got.brotli == nil {
+		t.Fatal("intact variants failed verification")
+	}
+
+	// flip a bit in the middle of the deflate stream
+	v.gzipped[len(v.gzipped)/2] ^= 0x10
+	if got := verifiedVariants(&ce, v, c); got.gzipped != nil || got.brotli != nil {
+		t.Error("corrupted variants passed verification")
+	}
+	if _, attrs, ok := rec.find("spa: compressed content failed verification, serving it uncompressed"); !ok || attrs["path"].String() != "/index.html" {
+		t.Error("got no error logged for the corrupted variant")
+	}
+
+	entries := []cacheEntry{ce}
+	attachVariants(&entries[0], verifiedVariants(&ce, v, c), c)
+	wr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	r.Header.Set("Accept-Encoding", "gzip, br")
+	entries[0].ServeHTTP(wr, r)
+	if got := wr.Header().Get("Content-Encoding"); got != "" || wr.Body.String() != compressible {
+		t.Errorf("got Content-Encoding %q with %d bytes, want the identity content", got, wr.Body.Len())
+	}
+}
+
+func TestMinCompressionRatio(t *testing.T) {
+	// compresses to about 95% of its size - which still saves a few packets
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"data.txt":   incompressible(64<<10) + strings.Repeat("\x00", 4<<10),
+	}
+
+	h := newTestHandler(t, files)
+	entry := h.entries()["/data.txt"]
+	if ratio := float64(entry.compressedSize) / float64(entry.identitySize); entry.gzipHandler == nil || ratio < 0.9 || ratio > 0.97 {
+		t.Fatalf("without a minimum: got a %d byte gzip variant of %d bytes, want one of about 95%%", entry.compressedSize, entry.identitySize)
+	}
+
+	h = newTestHandler(t, files, WithMinCompressionRatio(0.9))
+	if entry := h.entries()["/data.txt"]; entry.gzipHandler != nil || entry.brotliHandler != nil || entry.shouldServeCompressed {
+		t.Errorf("minimum of 0.9: got a %d byte gzip variant of %d bytes, want none", entry.compressedSize, entry.identitySize)
+	}
+
+	for _, ratio := range []float64{-0.1, 1.1} {
+		if _, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithMinCompressionRatio(ratio)); err == nil {
+			t.Errorf("ratio %v: got no error", ratio)
+		}
+	}
+}
+
+func TestSaveData(t *testing.T) {
+	// compresses a little, but not enough to save a packet
+	borderline := incompressible(2950) + strings.Repeat("\x00", 150)
+	h := newTestHandler(t, map[string]string{
+		"index.html":    "<html></html>",
+		"borderline.js": borderline,
+	})
+
+	entry := h.entries()["/borderline.js"]
+	if entry.gzipHandler != nil || len(entry.saveDataVariants) == 0 {
+		t.Fatalf("got gzip handler %v with Save-Data variants %v, want only the latter", entry.gzipHandler != nil, entry.saveDataVariants)
+	}
+
+	for _, tt := range []struct {
+		acceptEncoding string
+		saveData       string
+		encoding       string
+	}{
+		{"gzip", "", ""},
+		{"gzip", "on", "gzip"},
+		{"gzip", "On ", "gzip"},
+		{"gzip", "off", ""},
+		// the client has to accept it all the same
+		{"", "on", ""},
+	} {
+		wr := serve(h, http.MethodGet, "/borderline.js", "Accept-Encoding", tt.acceptEncoding, "Save-Data", tt.saveData)
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%q (Save-Data %q): got Content-Encoding %q, want %q", tt.acceptEncoding, tt.saveData, got, tt.encoding)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != borderline {
+			t.Errorf("%q (Save-Data %q): got different content", tt.acceptEncoding, tt.saveData)
+		}
+		if tt.encoding != "" && wr.Body.Len() >= len(borderline) {
+			t.Errorf("%q (Save-Data %q): got %d bytes, want fewer than %d", tt.acceptEncoding, tt.saveData, wr.Body.Len(), len(borderline))
+		}
+	}
+
+	// given a choice, the smallest
+	smallest, size := "", len(borderline)
+	for encoding, v := range entry.saveDataVariants {
+		if v.size < size {
+			smallest, size = encoding, v.size
+		}
+	}
+	wr := serve(h, http.MethodGet, "/borderline.js", "Accept-Encoding", "gzip, br", "Save-Data", "on")
+	if got := wr.Header().Get("Content-Encoding"); got != smallest || wr.Body.Len() != size {
+		t.Errorf("gzip, br: got Content-Encoding %q with %d bytes, want %q with %d", got, wr.Body.Len(), smallest, size)
+	}
+}
@@ -0,0 +1,134 @@
+package spa
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// content codings understood by the handler
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingBrotli   = "br"
+)
+
+// a single coding (and its quality value) from an Accept-Encoding header
+type acceptedEncoding struct {
+	coding string
+	q      float64
+}
+
+// parses an Accept-Encoding header value into its codings.
+// Malformed elements (e.g. an unparseable q value) are ignored.
+func parseAcceptEncoding(header string) []acceptedEncoding {
+	var ret []acceptedEncoding
+	for _, element := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		// x-gzip is an alias for gzip (RFC 9110 section 8.4.1.3)
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+
+		q, ok := parseQValue(params)
+		if !ok {
+			continue
+		}
+
+		ret = append(ret, acceptedEncoding{coding: coding, q: q})
+	}
+
+	return ret
+}
+
+// parses the parameters following a coding (e.g. " q=0.5").
+// Reports false if the q parameter is present but malformed.
+func parseQValue(params string) (float64, bool) {
+	params = strings.TrimSpace(params)
+	if params == "" {
+		return 1, true
+	}
+
+	name, value, ok := strings.Cut(params, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+		return 0, false
+	}
+
+	q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+
+	return q, true
+}
+
+// returns the quality value the client assigned to coding.
+// Codings the client did not list take the quality of its "*" (if any), and
+// are otherwise not acceptable - except identity, which is acceptable unless
+// explicitly refused (RFC 9110 section 12.5.3).
+func encodingQuality(accepted []acceptedEncoding, coding string) float64 {
+	wildcard := -1.0
+	for _, a := range accepted {
+		if a.coding == coding {
+			return a.q
+		}
+		if a.coding == "*" {
+			wildcard = a.q
+		}
+	}
+
+	if wildcard >= 0 {
+		return wildcard
+	}
+
+	if coding == encodingIdentity {
+		return 1
+	}
+
+	return 0
+}
+
+// returns the Accept-Encoding header value without any "*" element
+func withoutWildcard(header string) string {
+	elements := strings.Split(header, ",")
+	kept := elements[:0]
+	for _, element := range elements {
+		coding, _, _ := strings.Cut(element, ";")
+		if strings.TrimSpace(coding) != "*" {
+			kept = append(kept, element)
+		}
+	}
+
+	return strings.Join(kept, ",")
+}
+
+// chooses the best of the available codings (listed in order of server
+// preference) for the client's Accept-Encoding header.
+// Reports false if none of them is acceptable to the client.
+//
+// A request without an Accept-Encoding header (e.g. from an HTTP/1.0 client)
+// is only served identity. RFC 9110 lets such a request take any coding, but
+// in practice clients that don't send the header can't be relied on to
+// decode one.
+func negotiateEncoding(header string, available ...string) (string, bool) {
+	if strings.TrimSpace(header) == "" {
+		return encodingIdentity, slices.Contains(available, encodingIdentity)
+	}
+
+	accepted := parseAcceptEncoding(header)
+
+	best, bestQ := "", 0.0
+	for _, coding := range available {
+		// ties go to the coding listed first
+		if q := encodingQuality(accepted, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best, bestQ > 0
+}
@@ -0,0 +1,224 @@
+package spa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := []string{encodingBrotli, encodingGzip, encodingIdentity}
+
+	for _, tt := range []struct {
+		header   string
+		encoding string
+		ok       bool
+	}{
+		{"", encodingIdentity, true},
+		{"gzip", encodingGzip, true},
+		{"gzip;q=0", encodingIdentity, true},
+		{"gzip;q=0, br;q=0", encodingIdentity, true},
+		{"gzip;q=0.5, br", encodingBrotli, true},
+		{"gzip, br;q=0.5", encodingGzip, true},
+		{"x-gzip", encodingGzip, true},
+		{"x-gzip-fake", encodingIdentity, true},
+		{"identity;q=0", "", false},
+		{"*;q=0", "", false},
+		{"*", encodingBrotli, true},
+		// malformed elements are ignored, rather than taken as acceptable
+		{"gzip;q=abc", encodingIdentity, true},
+		{"gzip;q=2, br;level=1", encodingIdentity, true},
+		{";;, ,", encodingIdentity, true},
+	} {
+		encoding, ok := negotiateEncoding(tt.header, available...)
+		if ok != tt.ok || (ok && encoding != tt.encoding) {
+			t.Errorf("%q: got %q (%v), want %q (%v)", tt.header, encoding, ok, tt.encoding, tt.ok)
+		}
+	}
+}
+
+func TestServeAcceptEncoding(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"small.txt":  "hello",
+	})
+
+	for _, tt := range []struct {
+		path           string
+		acceptEncoding string
+		status         int
+		encoding       string
+	}{
+		{"/index.html", "gzip;q=0", http.StatusOK, ""},
+		{"/index.html", "gzip;q=0, br", http.StatusOK, "br"},
+		{"/index.html", "identity;q=0", http.StatusNotAcceptable, ""},
+		{"/index.html", "gzip, identity;q=0", http.StatusOK, "gzip"},
+		{"/index.html", "gzip;q=nope", http.StatusOK, ""},
+		// nothing but identity is available for content that isn't compressed
+		{"/small.txt", "gzip, identity;q=0", http.StatusNotAcceptable, ""},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept-Encoding", tt.acceptEncoding)
+		if wr.Code != tt.status {
+			t.Errorf("%s (%q): got status %d, want %d", tt.path, tt.acceptEncoding, wr.Code, tt.status)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s (%q): got Content-Encoding %q, want %q", tt.path, tt.acceptEncoding, got, tt.encoding)
+		}
+	}
+}
+
+func TestServeNotAcceptable(t *testing.T) {
+	files := map[string]string{
+		"index.html": compressible,
+		"logo.png":   incompressible(8 << 10),
+	}
+
+	for _, tt := range []struct {
+		name           string
+		opts           []Option
+		path           string
+		acceptEncoding string
+		status         int
+	}{
+		{"incompressible", nil, "/logo.png", "identity;q=0, gzip;q=0", http.StatusNotAcceptable},
+		{"incompressible, anything but identity", nil, "/logo.png", "*;q=0", http.StatusNotAcceptable},
+		{"compression disabled", []Option{WithCompression(false)}, "/index.html", "gzip, identity;q=0", http.StatusNotAcceptable},
+		{"compressed", nil, "/index.html", "identity;q=0, gzip", http.StatusOK},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		wr := serve(h, http.MethodGet, tt.path, "Accept-Encoding", tt.acceptEncoding)
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, tt.status)
+		}
+		if tt.status == http.StatusNotAcceptable && wr.Body.String() == files[tt.path[1:]] {
+			t.Errorf("%s: got the identity content anyway", tt.name)
+		}
+	}
+}
+
+func TestServeNoAcceptEncoding(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"app.js":     compressible,
+		"video.mp4":  incompressible(64 << 10),
+	}, WithMaxInMemoryBytes(32<<10))
+
+	for _, tt := range []struct {
+		path    string
+		headers []string
+		content string
+	}{
+		{"/app.js", nil, compressible},
+		{"/index.html", nil, compressible},
+		{"/some/route", []string{"Accept", "text/html"}, compressible},
+		{"/app.js", []string{"Save-Data", "on"}, compressible},
+		{"/video.mp4", nil, incompressible(64 << 10)},
+	} {
+		for _, proto := range []string{"HTTP/1.1", "HTTP/1.0"} {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Proto = proto
+			r.ProtoMinor = int(proto[len(proto)-1] - '0')
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				r.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			wr := httptest.NewRecorder()
+			h.ServeHTTP(wr, r)
+
+			if wr.Code != http.StatusOK || wr.Body.String() != tt.content {
+				t.Errorf("%s (%s): got status %d with %d bytes, want %d with the %d identity bytes", tt.path, proto, wr.Code, wr.Body.Len(), http.StatusOK, len(tt.content))
+			}
+			if _, ok := wr.Header()["Content-Encoding"]; ok {
+				t.Errorf("%s (%s): got Content-Encoding %q, want none", tt.path, proto, wr.Header().Get("Content-Encoding"))
+			}
+			if got, want := wr.Header().Get("Content-Length"), strconv.Itoa(len(tt.content)); got != want {
+				t.Errorf("%s (%s): got Content-Length %q, want %q", tt.path, proto, got, want)
+			}
+		}
+	}
+}
+
+func TestDebugEncodingParam(t *testing.T) {
+	files := map[string]string{"index.html": compressible}
+	// under which the index is revalidated
+	h := newTestHandler(t, files, WithDebugEncodingParam("enc"), WithCacheControl(time.Hour))
+
+	for _, tt := range []struct {
+		target         string
+		acceptEncoding string
+		encoding       string
+		forced         bool
+	}{
+		{"/index.html?enc=identity", "gzip, br", "", true},
+		{"/index.html?enc=gzip", "", "gzip", true},
+		{"/index.html?enc=gzip", "br", "gzip", true},
+		{"/index.html?enc=br", "gzip", "br", true},
+		// unknown values are ignored
+		{"/index.html?enc=zstd", "gzip", "gzip", false},
+		{"/index.html?enc=", "", "", false},
+		{"/index.html?other=gzip", "", "", false},
+	} {
+		wr := serve(h, http.MethodGet, tt.target, "Accept-Encoding", tt.acceptEncoding)
+		if wr.Code != http.StatusOK {
+			t.Errorf("%s (%q): got status %d, want %d", tt.target, tt.acceptEncoding, wr.Code, http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s (%q): got Content-Encoding %q, want %q", tt.target, tt.acceptEncoding, got, tt.encoding)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != compressible {
+			t.Errorf("%s (%q): got different content", tt.target, tt.acceptEncoding)
+		}
+
+		// a forced response mustn't be cached for others
+		want := "no-cache"
+		if tt.forced {
+			want = "no-store"
+		}
+		if got := wr.Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s (%q): got Cache-Control %q, want %q", tt.target, tt.acceptEncoding, got, want)
+		}
+	}
+
+	// inert by default
+	h = newTestHandler(t, files)
+	wr := serve(h, http.MethodGet, "/index.html?enc=gzip")
+	if got := wr.Header().Get("Content-Encoding"); got != "" || wr.Header().Get("Cache-Control") == "no-store" {
+		t.Errorf("default: got Content-Encoding %q and Cache-Control %q, want neither", got, wr.Header().Get("Cache-Control"))
+	}
+}
+
+func TestServeHTTP10(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+
+	for _, tt := range []struct {
+		proto          string
+		acceptEncoding string
+		encoding       string
+	}{
+		// nothing a legacy intermediary might pass along unknowingly
+		{"HTTP/1.0", "*", ""},
+		{"HTTP/1.0", "identity, *;q=0.5", ""},
+		{"HTTP/1.0", "gzipped", ""},
+		{"HTTP/1.0", "x-gzip-fake", ""},
+		{"HTTP/1.0", "", ""},
+		// but a coding the client named itself is trusted
+		{"HTTP/1.0", "gzip", "gzip"},
+		{"HTTP/1.0", "*, gzip", "gzip"},
+		{"HTTP/1.1", "*", "br"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+		r.Proto, r.ProtoMajor, r.ProtoMinor = tt.proto, 1, int(tt.proto[len(tt.proto)-1]-'0')
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		wr := httptest.NewRecorder()
+		h.ServeHTTP(wr, r)
+
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s (%q): got Content-Encoding %q, want %q", tt.proto, tt.acceptEncoding, got, tt.encoding)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != compressible {
+			t.Errorf("%s (%q): got different content", tt.proto, tt.acceptEncoding)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package spa
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// NewFileHandler creates a new [http.Handler] that serves data (e.g. a
+// robots.txt or a health check page) as contentType, with the same
+// compression, ETag, and conditional request handling as the files served by
+// a [Handler]. If contentType is empty, it is sniffed from data.
+//
+// Of opts, those that configure how content is compressed apply - as do
+// those that configure the headers and error responses of every response
+// (e.g. [WithHeaders], [WithoutNosniff], and [WithErrorHandler]). Like a
+// [Handler], it only serves GET and HEAD requests.
+func NewFileHandler(contentType string, data []byte, opts ...Option) http.Handler {
+	c := newConfig(opts)
+
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	entries := []cacheEntry{buildCacheEntry("", contentType, data, time.Time{})}
+	err := compressEntries(context.Background(), entries, c, 1)
+	if err != nil {
+		// still servable, just not compressed
+		c.logger.Error("spa: error compressing file", "err", err)
+		entries[0] = buildCacheEntry("", contentType, data, time.Time{})
+	}
+
+	return fileHandler{entry: entries[0], config: c}
+}
+
+// serves a single in-memory file (see [NewFileHandler])
+type fileHandler struct {
+	entry  cacheEntry
+	config *config
+}
+
+func (fh fileHandler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	if !fh.config.startResponse(wr, r) {
+		return
+	}
+
+	fh.entry.serve(wr, r, fh.config.errorHandler)
+}
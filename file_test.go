@@ -0,0 +1,57 @@
+package spa
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewFileHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		data     string
+		encoding string
+	}{
+		{"without gzip", "User-agent: *", ""},
+		{"with gzip", compressible, "gzip"},
+	} {
+		h := NewFileHandler("text/plain; charset=utf-8", []byte(tt.data), WithLogger(discardLogger))
+
+		wr := serve(h, http.MethodGet, "/", "Accept-Encoding", "gzip")
+		if wr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != tt.data {
+			t.Errorf("%s: got %q, want the data", tt.name, got)
+		}
+		if got := wr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: got X-Content-Type-Options %q, want nosniff", tt.name, got)
+		}
+
+		for method, status := range map[string]int{
+			http.MethodPost:    http.StatusMethodNotAllowed,
+			http.MethodDelete:  http.StatusMethodNotAllowed,
+			http.MethodOptions: http.StatusNoContent,
+		} {
+			wr := serve(h, method, "/", "Accept-Encoding", "gzip")
+			if wr.Code != status || wr.Header().Get("Allow") != allowedMethods {
+				t.Errorf("%s: %s got status %d with Allow %q, want %d with %q", tt.name, method, wr.Code, wr.Header().Get("Allow"), status, allowedMethods)
+			}
+			if wr.Body.String() == tt.data || wr.Header().Get("Content-Encoding") != "" {
+				t.Errorf("%s: %s got the file's content", tt.name, method)
+			}
+		}
+	}
+
+	// without nosniff, and with headers of its own
+	h := NewFileHandler("text/plain", []byte("ok"), WithLogger(discardLogger), WithoutNosniff(), WithHeaders(map[string]string{"X-Robots-Tag": "noindex"}))
+	wr := serve(h, http.MethodGet, "/")
+	if got := wr.Header().Get("X-Content-Type-Options"); got != "" {
+		t.Errorf("WithoutNosniff: got X-Content-Type-Options %q", got)
+	}
+	if got := wr.Header().Get("X-Robots-Tag"); got != "noindex" {
+		t.Errorf("WithHeaders: got X-Robots-Tag %q, want noindex", got)
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/a-jentleman/spa
 
-go 1.20.1
+go 1.23
+
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/fsnotify/fsnotify v1.10.1
+)
+
+require golang.org/x/sys v0.13.0 // indirect
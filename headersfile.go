@@ -0,0 +1,123 @@
+package spa
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// name of the file (at the root of a source) declaring per-path headers
+const headersFile = "_headers"
+
+// a path pattern from a _headers file, and the headers set on matching paths
+type headerRule struct {
+	pattern string
+	headers [][2]string
+}
+
+// reads the _headers files at the roots of sources (those that have one),
+// and returns their rules in order
+func loadHeaderRules(sources []source) ([]headerRule, error) {
+	var ret []headerRule
+	for _, src := range sources {
+		bs, err := fs.ReadFile(src.fsys, headersFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("spa: failed to read %s: %w", headersFile, err)
+		}
+
+		rules, err := parseHeaderRules(bs)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, rules...)
+	}
+
+	return ret, nil
+}
+
+// parses the rules of a Netlify-style _headers file, e.g.
+//
+//	# comments and blank lines are ignored
+//	/assets/*
+//	  Cache-Control: public, max-age=31536000, immutable
+//	/admin/:page
+//	  Content-Security-Policy: default-src 'self'
+//
+// Each unindented line starts a rule for a path pattern, and the indented
+// lines that follow are the headers set on paths matching it.
+func parseHeaderRules(bs []byte) ([]headerRule, error) {
+	var ret []headerRule
+
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			if !strings.HasPrefix(trimmed, "/") {
+				return nil, fmt.Errorf("spa: %s:%d: path %q must start with '/'", headersFile, lineno, trimmed)
+			}
+
+			ret = append(ret, headerRule{pattern: trimmed})
+			continue
+		}
+
+		if len(ret) == 0 {
+			return nil, fmt.Errorf("spa: %s:%d: header before any path", headersFile, lineno)
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("spa: %s:%d: malformed header %q", headersFile, lineno, trimmed)
+		}
+
+		rule := &ret[len(ret)-1]
+		rule.headers = append(rule.headers, [2]string{http.CanonicalHeaderKey(name), strings.TrimSpace(value)})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("spa: failed to read %s: %w", headersFile, err)
+	}
+
+	return ret, nil
+}
+
+// Reports whether urlpath matches pattern, where a ":name" segment matches
+// any single segment, and a final "*" segment matches whatever remains
+// (including nothing).
+func (rule headerRule) matches(urlpath string) bool {
+	patternSegments := strings.Split(rule.pattern, "/")
+	pathSegments := strings.Split(urlpath, "/")
+
+	for i, segment := range patternSegments {
+		if segment == "*" && i == len(patternSegments)-1 {
+			return true
+		}
+
+		if i >= len(pathSegments) {
+			return false
+		}
+
+		if strings.HasPrefix(segment, ":") && pathSegments[i] != "" {
+			continue
+		}
+
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+
+	return len(patternSegments) == len(pathSegments)
+}
@@ -0,0 +1,88 @@
+package spa
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeadersFile(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":    "<html></html>",
+		"assets/app.js": "console.log(1)",
+		"admin/users":   "<html>users</html>",
+		"_headers": `# a Netlify-style _headers file
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+
+/admin/:page
+  content-security-policy: default-src 'self'
+  X-Frame-Options: DENY
+`,
+	}, WithHeadersFile())
+
+	for _, tt := range []struct {
+		path    string
+		headers map[string]string
+	}{
+		{"/assets/app.js", map[string]string{
+			"Cache-Control":           "public, max-age=31536000, immutable",
+			"Content-Security-Policy": "",
+		}},
+		{"/admin/users", map[string]string{
+			"Cache-Control":           "",
+			"Content-Security-Policy": "default-src 'self'",
+			"X-Frame-Options":         "DENY",
+		}},
+		{"/index.html", map[string]string{
+			"Cache-Control":           "",
+			"Content-Security-Policy": "",
+			"X-Frame-Options":         "",
+		}},
+	} {
+		wr := serve(h, http.MethodGet, tt.path)
+		for name, want := range tt.headers {
+			if got := wr.Header().Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", tt.path, name, got, want)
+			}
+		}
+	}
+
+	// the file itself is still skipped
+	if wr := serve(h, http.MethodGet, "/_headers", "Accept", "*/*"); wr.Code != http.StatusNotFound {
+		t.Errorf("/_headers: got status %d, want %d", wr.Code, http.StatusNotFound)
+	}
+}
+
+func TestParseHeaderRulesInvalid(t *testing.T) {
+	for _, content := range []string{
+		"  X-Frame-Options: DENY\n",
+		"assets/*\n  X-Frame-Options: DENY\n",
+		"/assets/*\n  no colon here\n",
+	} {
+		if _, err := parseHeaderRules([]byte(content)); err == nil {
+			t.Errorf("%q: got no error", content)
+		}
+	}
+}
+
+func TestHeaderRuleMatches(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/assets/*", "/assets/app.js", true},
+		{"/assets/*", "/assets/js/app.js", true},
+		// the splat may match nothing
+		{"/assets/*", "/assets", true},
+		{"/assets/*", "/static/app.js", false},
+		{"/admin/:page", "/admin/users", true},
+		{"/admin/:page", "/admin/users/1", false},
+		{"/index.html", "/index.html", true},
+		{"/index.html", "/other.html", false},
+	} {
+		if got := (headerRule{pattern: tt.pattern}).matches(tt.path); got != tt.want {
+			t.Errorf("%s matching %s: got %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
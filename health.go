@@ -0,0 +1,19 @@
+package spa
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// writes the health check response to r
+func (h *Handler) serveHealth(wr http.ResponseWriter, r *http.Request) {
+	body := `{"status":"ok","routes":` + strconv.Itoa(len(h.entries())) + "}\n"
+
+	wr.Header().Set("Content-Type", "application/json")
+	wr.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	wr.Header().Set("Cache-Control", "no-store")
+	wr.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		wr.Write([]byte(body))
+	}
+}
@@ -0,0 +1,36 @@
+package spa
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHealthPath(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		"app.js":     "console.log(1)",
+		// shadowed by the health check
+		"healthz": "static",
+	}, WithHealthPath("/healthz"))
+
+	wr := serve(h, http.MethodGet, "/healthz", "Accept", "text/html")
+	if wr.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", wr.Code, http.StatusOK)
+	}
+	if got := wr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+
+	var body struct {
+		Status string `json:"status"`
+		Routes int    `json:"routes"`
+	}
+	if err := json.Unmarshal(wr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("got %q: %v", wr.Body.String(), err)
+	}
+	if body.Status != "ok" || body.Routes != len(h.Routes()) {
+		t.Errorf("got %+v, want ok with %d routes", body, len(h.Routes()))
+	}
+
+}
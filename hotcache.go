@@ -0,0 +1,78 @@
+package spa
+
+import (
+	"container/list"
+	"sync"
+)
+
+// a bounded, least-recently-used cache of the content of streamed files
+type hotCache struct {
+	maxBytes int64
+
+	mu sync.Mutex
+	// total size of the content held
+	size int64
+	// most recently used at the front
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+// an item in a hotCache
+type hotItem struct {
+	// etag of the content
+	key     string
+	content []byte
+}
+
+func newHotCache(maxBytes int64) *hotCache {
+	return &hotCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Reports whether content of size bytes could be held at all
+func (hc *hotCache) fits(size int64) bool {
+	return size <= hc.maxBytes
+}
+
+// returns the content cached under key, marking it as recently used
+func (hc *hotCache) get(key string) ([]byte, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	elem, ok := hc.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	hc.lru.MoveToFront(elem)
+	return elem.Value.(*hotItem).content, true
+}
+
+// caches content under key, evicting the least recently used content
+// until the total fits within maxBytes
+func (hc *hotCache) add(key string, content []byte) {
+	if !hc.fits(int64(len(content))) {
+		return
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	// a concurrent miss may have beaten us to it
+	if _, ok := hc.items[key]; ok {
+		return
+	}
+
+	hc.items[key] = hc.lru.PushFront(&hotItem{key: key, content: content})
+	hc.size += int64(len(content))
+
+	for hc.size > hc.maxBytes {
+		oldest := hc.lru.Back()
+		item := hc.lru.Remove(oldest).(*hotItem)
+		delete(hc.items, item.key)
+		hc.size -= int64(len(item.content))
+	}
+}
@@ -0,0 +1,56 @@
+package spa
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestHotCacheReadsOnce(t *testing.T) {
+	large := incompressible(64 << 10)
+	fsys := &countingFS{FS: os.DirFS(writeTree(t, map[string]string{
+		"index.html": "<html></html>",
+		"video.mp4":  large,
+	}))}
+
+	h, err := NewHandlerFS(fsys, WithLogger(discardLogger), WithMaxInMemoryBytes(32<<10), WithHotCache(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := fsys.count("video.mp4")
+
+	for range 2 {
+		if wr := serve(h, http.MethodGet, "/video.mp4"); wr.Code != http.StatusOK || wr.Body.String() != large {
+			t.Fatalf("got status %d with %d bytes, want the file", wr.Code, wr.Body.Len())
+		}
+	}
+
+	if got := fsys.count("video.mp4") - scanned; got != 1 {
+		t.Errorf("served twice, read %d times, want once", got)
+	}
+}
+
+func TestHotCacheEvictsOldest(t *testing.T) {
+	hc := newHotCache(250)
+
+	hc.add("a", make([]byte, 100))
+	hc.add("b", make([]byte, 100))
+	// a is now more recently used than b
+	hc.get("a")
+	hc.add("c", make([]byte, 100))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := hc.get(key); ok != want {
+			t.Errorf("%s: cached is %v, want %v", key, ok, want)
+		}
+	}
+	if hc.size != 200 {
+		t.Errorf("holding %d bytes, want 200", hc.size)
+	}
+
+	// content over the budget is never held
+	hc.add("d", make([]byte, 300))
+	if _, ok := hc.get("d"); ok || hc.size != 200 {
+		t.Errorf("content over the budget is cached (holding %d bytes)", hc.size)
+	}
+}
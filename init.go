@@ -1,6 +1,10 @@
 package spa
 
-import "mime"
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
 
 func init() {
 	mime.AddExtensionType(".html", "text/html")
@@ -11,6 +15,9 @@ func init() {
 	mime.AddExtensionType(".xhtml", "application/xhtml+xml")
 	mime.AddExtensionType(".xml", "application/xml")
 	mime.AddExtensionType(".json", "application/json")
+	mime.AddExtensionType(".map", "application/json")
+	mime.AddExtensionType(".webmanifest", "application/manifest+json")
+	mime.AddExtensionType(".wasm", "application/wasm")
 	mime.AddExtensionType(".zip", "application/zip")
 
 	mime.AddExtensionType(".mp3", "audio/mpeg")
@@ -23,9 +30,66 @@ func init() {
 	mime.AddExtensionType(".jpeg", "image/jpeg")
 	mime.AddExtensionType(".tif", "image/tiff")
 	mime.AddExtensionType(".tiff", "image/tiff")
+	mime.AddExtensionType(".svg", "image/svg+xml")
+	mime.AddExtensionType(".webp", "image/webp")
+	mime.AddExtensionType(".ico", "image/vnd.microsoft.icon")
 
 	mime.AddExtensionType(".ttf", "font/ttf")
+	mime.AddExtensionType(".woff", "font/woff")
+	mime.AddExtensionType(".woff2", "font/woff2")
 }
 
-func addMimeMapping(ext string, contentType string) {
+// registers contentType as the mime type for files ending in ext (e.g. ".wasm").
+// Note that this registration is global to the process (see [mime.AddExtensionType]).
+func addMimeMapping(ext string, contentType string) error {
+	if !strings.HasPrefix(ext, ".") {
+		return fmt.Errorf("spa: extension %q must start with '.'", ext)
+	}
+
+	if contentType == "" {
+		return fmt.Errorf("spa: empty content type for extension %s", ext)
+	}
+
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return fmt.Errorf("spa: invalid content type %q for extension %s: %w", contentType, ext, err)
+	}
+
+	if err := mime.AddExtensionType(ext, contentType); err != nil {
+		return fmt.Errorf("spa: failed to register content type %q for extension %s: %w", contentType, ext, err)
+	}
+
+	return nil
+}
+
+// textual content types that aren't text/*. Note that text/* types get their
+// charset from withCharset too: init registers .html, .js, .mjs and .txt
+// without one, replacing e.g. the mime package's text/html; charset=utf-8.
+var charsetTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/xml":           true,
+	"application/xhtml+xml":     true,
+	"application/manifest+json": true,
+	"image/svg+xml":             true,
+}
+
+// returns contentType with a charset of utf-8 if it is textual and
+// doesn't specify a charset already, so browsers don't have to guess
+func withCharset(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] != "" {
+		return contentType
+	}
+
+	if !isTextual(mediaType) {
+		return contentType
+	}
+
+	params["charset"] = "utf-8"
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// Reports whether mediaType (without parameters) is a textual format
+func isTextual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || charsetTypes[mediaType]
 }
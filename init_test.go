@@ -0,0 +1,152 @@
+package spa
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMimeType(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":   "<html></html>",
+		"app.wasm":     "\x00asm",
+		"data.spatest": "{}",
+	}, WithMimeType(".wasm", "application/wasm"), WithMimeType(".spatest", "application/x-spa-test"))
+
+	for path, want := range map[string]string{
+		"/app.wasm":     "application/wasm",
+		"/data.spatest": "application/x-spa-test",
+	} {
+		if got := serve(h, http.MethodGet, path).Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: got Content-Type %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestWithMimeTypeInvalid(t *testing.T) {
+	dir := writeTree(t, map[string]string{"index.html": "<html></html>"})
+
+	for _, tt := range [][2]string{
+		{"wasm", "application/wasm"},
+		{".wasm", ""},
+		{".wasm", "application/"},
+	} {
+		if _, err := NewHandlerWithOptions(dir, WithLogger(discardLogger), WithMimeType(tt[0], tt[1])); err == nil {
+			t.Errorf("WithMimeType(%q, %q): got no error", tt[0], tt[1])
+		}
+	}
+}
+
+func TestModernMimeTypes(t *testing.T) {
+	for _, tt := range []struct {
+		ext         string
+		contentType string
+		compressed  bool
+	}{
+		{".wasm", "application/wasm", false},
+		{".webmanifest", "application/manifest+json", false},
+		{".svg", "image/svg+xml", false},
+		{".woff", "font/woff", true},
+		{".woff2", "font/woff2", true},
+		{".webp", "image/webp", true},
+		{".ico", "image/vnd.microsoft.icon", false},
+		{".map", "application/json", false},
+	} {
+		got := mime.TypeByExtension(tt.ext)
+		if mediaTypeOf(got) != tt.contentType {
+			t.Errorf("%s: got %q, want %q", tt.ext, got, tt.contentType)
+		}
+		if contentTypeIsAlreadyCompressed(got, nil) != tt.compressed {
+			t.Errorf("%s: already compressed is %v, want %v", tt.ext, !tt.compressed, tt.compressed)
+		}
+	}
+}
+
+func TestCharset(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":     "<html></html>",
+		"app.js":         "console.log(1)",
+		"data.json":      "{}",
+		"logo.svg":       "<svg></svg>",
+		"logo.png":       "\x89PNG",
+		"latin1.spatest": "caf\xe9",
+	}, WithMimeType(".spatest", "text/plain; charset=iso-8859-1"))
+
+	for urlpath, want := range map[string]string{
+		"/index.html":     "text/html; charset=utf-8",
+		"/app.js":         "text/javascript; charset=utf-8",
+		"/data.json":      "application/json; charset=utf-8",
+		"/logo.svg":       "image/svg+xml; charset=utf-8",
+		"/logo.png":       "image/png",
+		"/latin1.spatest": "text/plain; charset=iso-8859-1",
+	} {
+		if got := serve(h, http.MethodGet, urlpath).Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: got Content-Type %q, want %q", urlpath, got, want)
+		}
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":    "<html></html>",
+		"LICENSE":       "Permission is hereby granted, free of charge, to any person",
+		"blob":          "\x00\x01\x02\x03\xfe\xff",
+		"data.unknownx": "\x00\x10binary\x00",
+		"page.unknownx": "<!DOCTYPE html><html></html>",
+	})
+
+	for urlpath, want := range map[string]string{
+		"/LICENSE":       "text/plain; charset=utf-8",
+		"/blob":          "application/octet-stream",
+		"/data.unknownx": "application/octet-stream",
+		"/page.unknownx": "text/html; charset=utf-8",
+	} {
+		if got := serve(h, http.MethodGet, urlpath).Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: got Content-Type %q, want %q", urlpath, got, want)
+		}
+	}
+}
+
+func TestExtensionlessContentType(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"LICENSE":    "Permission is hereby granted, free of charge, to any person\n" + compressible,
+		"data":       "\x00\x01\x02" + strings.Repeat("\x00", 16<<10),
+		"tiny":       "\x00\x01",
+	}
+	h := newTestHandler(t, files, WithMinCompressSize(0))
+
+	for _, tt := range []struct {
+		path        string
+		contentType string
+		compressed  bool
+	}{
+		{"/LICENSE", "text/plain; charset=utf-8", true},
+		{"/data", "application/octet-stream", true},
+		{"/tiny", "application/octet-stream", false},
+	} {
+		for _, acceptEncoding := range []string{"", "gzip", "br"} {
+			wr := serve(h, http.MethodGet, tt.path, "Accept-Encoding", acceptEncoding)
+
+			// the same, whichever coding the content is served with
+			if got := wr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("%s (%q): got Content-Type %q, want %q", tt.path, acceptEncoding, got, tt.contentType)
+			}
+
+			encoding := wr.Header().Get("Content-Encoding")
+			if want := map[bool]string{true: acceptEncoding}[tt.compressed]; encoding != want {
+				t.Errorf("%s (%q): got Content-Encoding %q, want %q", tt.path, acceptEncoding, encoding, want)
+			}
+			if got := decode(t, encoding, wr.Body); got != files[tt.path[1:]] {
+				t.Errorf("%s (%q): got different content", tt.path, acceptEncoding)
+			}
+		}
+	}
+
+	// an unknown type is never left empty
+	if got := buildCacheEntry("/blob", "", []byte("\x00"), time.Time{}).contentType; got != "application/octet-stream" {
+		t.Errorf("got content type %q, want application/octet-stream", got)
+	}
+}
@@ -0,0 +1,173 @@
+package spa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewLazyHandler creates a new [Handler] that serves out of dir, configured
+// by opts - like [NewHandlerWithOptions], except that each file is only read
+// (and compressed) on its first request, rather than all of them up front.
+//
+// This gets the handler serving sooner where startup latency matters more
+// than the first request for each file (e.g. a serverless cold start). Only
+// the directory tree is scanned eagerly, so the handler still fails to build
+// if e.g. the index is missing. Once read, a file is served from memory just
+// as if it had been cached up front.
+//
+// [WithPrecompressed] doesn't apply: sidecars are served as files of their own.
+func NewLazyHandler(dir string, opts ...Option) (*Handler, error) {
+	opts = append(opts[:len(opts):len(opts)], func(c *config) {
+		c.lazy = true
+	})
+
+	return newHandler(context.Background(), []source{{fsys: os.DirFS(dir), dir: dir}}, opts)
+}
+
+// a file in a lazy handler's cache that hasn't been read yet
+type lazyFile struct {
+	fsys  fs.FS
+	fpath string
+}
+
+// returns the cacheEntry standing in for the file at fpath in fsys (served at
+// urlpath as contentType, which is "" if it is to be sniffed) until it is read
+func buildLazyEntry(urlpath string, contentType string, modTime time.Time, fsys fs.FS, fpath string) cacheEntry {
+	return cacheEntry{
+		urlpath:        urlpath,
+		contentType:    contentType,
+		modTime:        modTime,
+		lazy:           &lazyFile{fsys: fsys, fpath: fpath},
+		source:         fsys,
+		fpath:          fpath,
+		compressedSize: -1,
+		brotliSize:     -1,
+	}
+}
+
+// returns entry, first reading (and caching) its content if it hasn't been yet.
+// Concurrent first requests for the same file only read it once.
+func (h *Handler) load(entry cacheEntry) (cacheEntry, error) {
+	if entry.lazy == nil {
+		return entry, nil
+	}
+
+	key := h.config.cacheKey(entry.urlpath)
+	return h.loading.do(key, func() (cacheEntry, error) {
+		// another request may have read it while this one was on its way here
+		if current, ok := h.entries()[key]; ok && current.lazy == nil {
+			return current, nil
+		}
+
+		c := *h.config
+		c.lazy = false
+
+		entries, err := appendFileEntry(nil, entry.urlpath, entry.lazy.fsys, entry.lazy.fpath, &c, make(map[string][]byte))
+		if err != nil {
+			return cacheEntry{}, err
+		}
+
+		err = compressEntries(context.Background(), entries, &c, 1)
+		if err != nil {
+			return cacheEntry{}, err
+		}
+
+		loaded := entries[0]
+		loaded.links = entry.links
+		h.replaceEntry(key, entry.lazy, loaded)
+
+		h.logger.Debug(fmt.Sprintf("spa: read file %s (%s) (%d bytes, %d gzipped, %d brotli)", loaded.urlpath, loaded.contentType, loaded.identitySize, loaded.compressedSize, loaded.brotliSize))
+		return loaded, nil
+	})
+}
+
+// replaces the unread file lazy in the current cache with entry - unless the
+// cache has since been replaced with one that doesn't hold lazy
+func (h *Handler) replaceEntry(key string, lazy *lazyFile, entry cacheEntry) {
+	for {
+		current := h.cache.Load()
+		if existing, ok := (*current)[key]; !ok || existing.lazy != lazy {
+			return
+		}
+
+		next := maps.Clone(*current)
+		next[key] = entry
+		if h.cache.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+// returns entry ready to serve to r (see [Handler.load]) - or, failing that,
+// writes the error response itself and reports false
+func (h *Handler) loaded(wr http.ResponseWriter, r *http.Request, entry cacheEntry) (cacheEntry, bool) {
+	ret, err := h.load(entry)
+	if err == nil {
+		return ret, true
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		// deleted since the scan, just like a streamed file can be
+		h.logger.Warn("spa: file is gone since it was scanned", "path", entry.urlpath, "err", err)
+		if h.config.evict != nil {
+			h.config.evict(&entry)
+		}
+		writeError(wr, r, http.StatusNotFound, h.errorHandler)
+		return cacheEntry{}, false
+	}
+
+	h.logger.Error("spa: error reading file", "path", entry.urlpath, "err", err)
+	writeError(wr, r, http.StatusInternalServerError, h.errorHandler)
+	return cacheEntry{}, false
+}
+
+// deduplicates concurrent loads of the same file
+type loadGroup struct {
+	mu sync.Mutex
+	// loads in progress, by cache key (created on first use)
+	calls map[string]*loadCall
+}
+
+// a load in progress (or done) in a loadGroup
+type loadCall struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
+}
+
+// returns the result of fn, calling it only if no call for key is already in
+// progress - in which case the result of that one is returned instead
+func (g *loadGroup) do(key string, fn func() (cacheEntry, error)) (cacheEntry, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.entry, call.err
+	}
+
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// in case fn doesn't return (i.e. panics), so that waiters don't get a zero entry
+	call.err = errors.New("spa: loading file aborted")
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.entry, call.err = fn()
+	return call.entry, call.err
+}
@@ -0,0 +1,158 @@
+package spa
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// builds a lazy handler (see [NewLazyHandler]) serving out of fsys
+func newLazyTestHandler(t *testing.T, fsys fs.FS, opts ...Option) *Handler {
+	t.Helper()
+
+	opts = append([]Option{WithLogger(discardLogger)}, opts...)
+	opts = append(opts, func(c *config) {
+		c.lazy = true
+	})
+
+	h, err := newHandler(context.Background(), []source{{fsys: fsys}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// a [countingFS] whose opens of the file at name (if any) wait for gate to be
+// closed. Unlike a countingFS, it can stat files and read directories without
+// opening them - so that only reading a file's content counts as opening it.
+type gatedFS struct {
+	*countingFS
+	name string
+	gate chan struct{}
+}
+
+func newGatedFS(fsys fs.FS, name string) *gatedFS {
+	return &gatedFS{countingFS: &countingFS{FS: fsys}, name: name, gate: make(chan struct{})}
+}
+
+func (gfs *gatedFS) Open(name string) (fs.File, error) {
+	if name == gfs.name {
+		<-gfs.gate
+	}
+	return gfs.countingFS.Open(name)
+}
+
+func (gfs *gatedFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(gfs.FS, name)
+}
+
+func (gfs *gatedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(gfs.FS, name)
+}
+
+func TestLazyReadsOnRequest(t *testing.T) {
+	cfs := newGatedFS(fstest.MapFS{
+		"index.html":    {Data: []byte("<html></html>")},
+		"assets/app.js": {Data: []byte(compressible)},
+		"assets/a.css":  {Data: []byte("body{}")},
+	}, "")
+	h := newLazyTestHandler(t, cfs)
+
+	for _, name := range []string{"index.html", "assets/app.js", "assets/a.css"} {
+		if got := cfs.count(name); got != 0 {
+			t.Errorf("%s: read %d times before being requested", name, got)
+		}
+	}
+
+	for range 3 {
+		wr := serve(h, http.MethodGet, "/assets/app.js", "Accept-Encoding", "gzip")
+		if got := decode(t, wr.Header().Get("Content-Encoding"), wr.Body); wr.Code != http.StatusOK || got != compressible {
+			t.Errorf("got status %d with %d bytes, want %d with the file", wr.Code, len(got), http.StatusOK)
+		}
+	}
+
+	// only the requested file is read, and only once
+	for name, want := range map[string]int{"index.html": 0, "assets/app.js": 1, "assets/a.css": 0} {
+		if got := cfs.count(name); got != want {
+			t.Errorf("%s: read %d times, want %d", name, got, want)
+		}
+	}
+}
+
+func TestLazyConcurrentFirstRequests(t *testing.T) {
+	gfs := newGatedFS(fstest.MapFS{
+		"index.html": {Data: []byte("<html></html>")},
+		"app.js":     {Data: []byte(compressible)},
+	}, "app.js")
+	rec := &recordingHandler{}
+	h := newLazyTestHandler(t, gfs, WithLogger(slog.New(rec)))
+
+	const n = 16
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wr := serve(h, http.MethodGet, "/app.js", "Accept-Encoding", "gzip")
+			bodies[i] = decode(t, wr.Header().Get("Content-Encoding"), wr.Body)
+		}()
+	}
+
+	// so that every request arrives while the file is still being read
+	time.Sleep(50 * time.Millisecond)
+	close(gfs.gate)
+	wg.Wait()
+
+	for i, body := range bodies {
+		if body != compressible {
+			t.Errorf("request %d: got %d bytes, want the file", i, len(body))
+		}
+	}
+	if got := gfs.count("app.js"); got != 1 {
+		t.Errorf("read %d times, want once", got)
+	}
+
+	compressed := 0
+	rec.mu.Lock()
+	for _, r := range rec.records {
+		if strings.HasPrefix(r.Message, "spa: read file /app.js ") {
+			compressed++
+		}
+	}
+	rec.mu.Unlock()
+	if compressed != 1 {
+		t.Errorf("compressed %d times, want once", compressed)
+	}
+}
+
+func TestLazyStats(t *testing.T) {
+	h := newLazyTestHandler(t, fstest.MapFS{
+		"index.html": {Data: []byte("<html></html>")},
+		"app.js":     {Data: []byte(compressible)},
+		"copy.js":    {Data: []byte(compressible)},
+	})
+
+	// nothing is known of unread files, so they aren't e.g. taken for
+	// duplicates of each other
+	if got, want := h.Stats(), (HandlerStats{Entries: 3, LazyEntries: 3}); got != want {
+		t.Errorf("before any request: got %+v, want %+v", got, want)
+	}
+
+	serve(h, http.MethodGet, "/app.js")
+	serve(h, http.MethodGet, "/copy.js")
+
+	stats := h.Stats()
+	if stats.Entries != 3 || stats.LazyEntries != 1 || stats.CompressedEntries != 2 {
+		t.Errorf("after reading two: got %+v, want 3 entries, 1 lazy, and 2 compressed", stats)
+	}
+	if stats.IdentityBytes != int64(len(compressible)) || stats.DuplicateEntries != 1 {
+		t.Errorf("after reading two: got %d bytes with %d duplicates, want %d with 1", stats.IdentityBytes, stats.DuplicateEntries, len(compressible))
+	}
+}
@@ -0,0 +1,60 @@
+package spa
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// returns the names of the files and directories (the latter with a trailing
+// slash) directly within the directory at urlpath dir, sorted - or nil if
+// there is no such directory
+func listDir(cache map[string]cacheEntry, dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, entry := range cache {
+		rest, ok := strings.CutPrefix(entry.urlpath, prefix)
+		if !ok {
+			continue
+		}
+
+		name, _, isDir := strings.Cut(rest, "/")
+		if isDir {
+			name += "/"
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// writes an HTML listing of names, the contents of the directory at urlpath dir
+func (h *Handler) serveListing(wr http.ResponseWriter, r *http.Request, dir string, names []string) {
+	dir = strings.TrimSuffix(dir, "/") + "/"
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta charset=\"utf-8\">\n<title>Index of " + html.EscapeString(dir) + "</title>\n")
+	b.WriteString("<h1>Index of " + html.EscapeString(dir) + "</h1>\n<ul>\n")
+	for _, name := range names {
+		href := h.pathPrefix + dir + (&url.URL{Path: name}).EscapedPath()
+		b.WriteString("<li><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></li>\n")
+	}
+	b.WriteString("</ul>\n")
+
+	body := b.String()
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	wr.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		wr.Write([]byte(body))
+	}
+}
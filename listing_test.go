@@ -0,0 +1,66 @@
+package spa
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryListing(t *testing.T) {
+	files := map[string]string{
+		"index.html":          "<html></html>",
+		"docs/guide.html":     "<html>guide</html>",
+		"docs/a&b.txt":        "ampersand",
+		"docs/api/v1.json":    "{}",
+		"docs/api/v2.json":    "{}",
+		"blog/index.html":     "<html>blog</html>",
+		"blog/posts/one.html": "<html>one</html>",
+	}
+
+	h := newTestHandler(t, files, WithSPAFallback(false), WithDirectoryListing())
+
+	for _, target := range []string{"/docs/", "/docs"} {
+		wr := serve(h, http.MethodGet, target)
+		if wr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", target, wr.Code, http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q", target, got)
+		}
+
+		body := wr.Body.String()
+		want := []string{
+			"<title>Index of /docs/</title>",
+			`<li><a href="/docs/a&amp;b.txt">a&amp;b.txt</a></li>`,
+			`<li><a href="/docs/api/">api/</a></li>`,
+			`<li><a href="/docs/guide.html">guide.html</a></li>`,
+		}
+		last := -1
+		for _, s := range want {
+			i := strings.Index(body, s)
+			if i < 0 {
+				t.Errorf("%s: listing is missing %q:\n%s", target, s, body)
+			} else if i < last {
+				t.Errorf("%s: listing isn't sorted:\n%s", target, body)
+			}
+			last = i
+		}
+		if strings.Contains(body, "v1.json") {
+			t.Errorf("%s: listing includes the contents of subdirectories:\n%s", target, body)
+		}
+	}
+
+	// a directory's index.html is served instead
+	if wr := serve(h, http.MethodGet, "/blog/"); wr.Body.String() != files["blog/index.html"] {
+		t.Errorf("/blog/: got %q, want its index.html", wr.Body.String())
+	}
+	if wr := serve(h, http.MethodGet, "/nope/"); wr.Code != http.StatusNotFound {
+		t.Errorf("/nope/: got status %d, want %d", wr.Code, http.StatusNotFound)
+	}
+
+	// off by default, so that file names aren't exposed
+	h = newTestHandler(t, files, WithSPAFallback(false))
+	if wr := serve(h, http.MethodGet, "/docs/"); wr.Code != http.StatusNotFound || strings.Contains(wr.Body.String(), "guide.html") {
+		t.Errorf("default: got status %d with %q, want %d", wr.Code, wr.Body.String(), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,58 @@
+package spa
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the response served while a handler is in maintenance mode
+type maintenance struct {
+	// HTML page served with the 503 (nil for none)
+	page []byte
+	// value of the Retry-After header ("" for none)
+	retryAfter string
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on, every
+// request (but a health check, see [WithHealthPath]) gets a 503 Service
+// Unavailable - with page as its HTML body (or, if
+// page is nil, whatever [WithErrorHandler] writes), and a Retry-After header
+// of retryAfter (rounded up to whole seconds; none if it is not positive).
+//
+// It is safe to call concurrently with requests being served.
+func (h *Handler) SetMaintenance(on bool, page []byte, retryAfter time.Duration) {
+	if !on {
+		h.maintenance.Store(nil)
+		return
+	}
+
+	m := &maintenance{page: page}
+	if retryAfter > 0 {
+		seconds := int64((retryAfter + time.Second - 1) / time.Second)
+		m.retryAfter = strconv.FormatInt(seconds, 10)
+	}
+
+	h.maintenance.Store(m)
+}
+
+// writes the maintenance response to r
+func (m *maintenance) serve(wr http.ResponseWriter, r *http.Request, onError errorHandler) {
+	// mustn't outlive the maintenance
+	wr.Header().Set("Cache-Control", "no-store")
+	if m.retryAfter != "" {
+		wr.Header().Set("Retry-After", m.retryAfter)
+	}
+
+	if m.page == nil {
+		writeError(wr, r, http.StatusServiceUnavailable, onError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Content-Length", strconv.Itoa(len(m.page)))
+	wr.WriteHeader(http.StatusServiceUnavailable)
+	if r.Method != http.MethodHead {
+		wr.Write(m.page)
+	}
+}
@@ -0,0 +1,61 @@
+package spa
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"}, WithHealthPath("/healthz"))
+	page := []byte("<html>back soon</html>")
+
+	h.SetMaintenance(true, page, 90*time.Second+time.Millisecond)
+	for _, urlpath := range []string{"/index.html", "/some/route", "/missing.js"} {
+		wr := serve(h, http.MethodGet, urlpath, "Accept", "text/html")
+		if wr.Code != http.StatusServiceUnavailable || wr.Body.String() != string(page) {
+			t.Errorf("on: %s got status %d with %q, want %d with the page", urlpath, wr.Code, wr.Body.String(), http.StatusServiceUnavailable)
+		}
+		if got := wr.Header().Get("Retry-After"); got != "91" {
+			t.Errorf("on: %s got Retry-After %q, want 91", urlpath, got)
+		}
+	}
+	if wr := serve(h, http.MethodGet, "/healthz"); wr.Code != http.StatusOK {
+		t.Errorf("on: health check got status %d, want %d", wr.Code, http.StatusOK)
+	}
+
+	h.SetMaintenance(true, nil, 0)
+	wr := serve(h, http.MethodGet, "/index.html")
+	if wr.Code != http.StatusServiceUnavailable || wr.Header().Get("Retry-After") != "" {
+		t.Errorf("on without a page: got status %d with Retry-After %q", wr.Code, wr.Header().Get("Retry-After"))
+	}
+
+	h.SetMaintenance(false, nil, 0)
+	wr = serve(h, http.MethodGet, "/index.html")
+	if wr.Code != http.StatusOK || wr.Header().Get("Retry-After") != "" || wr.Body.String() != "<html></html>" {
+		t.Errorf("off: got status %d with %q, want the index", wr.Code, wr.Body.String())
+	}
+}
+
+func TestMaintenanceConcurrent(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				if i == 0 {
+					h.SetMaintenance(j%2 == 0, nil, time.Second)
+					continue
+				}
+				if wr := serve(h, http.MethodGet, "/index.html"); wr.Code != http.StatusOK && wr.Code != http.StatusServiceUnavailable {
+					t.Errorf("got status %d", wr.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,783 @@
+package spa
+
+import (
+	"compress/gzip"
+	"context"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures the handler created by [NewHandlerWithOptions] or [NewHandlerFS].
+//
+// Options are applied in the order they are given; when two options
+// configure the same behavior, the later one wins.
+type Option func(*config)
+
+// config holds the settings a handler is built with
+type config struct {
+	// urlpath of the SPA fallback (e.g. /index.html)
+	indexPath string
+	// true to serve the index for unknown routes
+	spaFallback bool
+	// true to only serve the index for unknown routes to navigation requests
+	navigationFallback bool
+	// true to answer requests for unknown paths with a file extension that
+	// aren't navigations with a 404
+	assetNotFound bool
+	// urlpath of the page served for missing assets ("" for a bare 404)
+	notFoundPath string
+	// urlpath of the page served for internal errors ("" for none)
+	serverErrorPath string
+	// rewrites the HTML served for a request (nil for none)
+	htmlTransform func(r *http.Request, html []byte) []byte
+	// content of /robots.txt and /favicon.ico where no such files exist (nil for none)
+	defaultRobots  []byte
+	defaultFavicon []byte
+	// urlpaths served the file at another urlpath
+	aliases map[string]string
+	// urlpaths of the files preloaded by the index
+	preload []string
+	// request path prefix the handler is mounted under ("" for none)
+	pathPrefix string
+	// how paths differing only by a trailing slash are redirected (0 for not at all)
+	trailingSlash TrailingSlashMode
+	// true to redirect paths that aren't in their cleaned form
+	canonicalRedirect bool
+	// true to list the contents of directories without an index.html
+	directoryListing bool
+	// name of the query parameter forcing an encoding ("" for none)
+	debugEncodingParam string
+	// writes error responses (nil for bare status lines)
+	errorHandler errorHandler
+	// removes the entry for a streamed file found to be gone from the cache
+	// (nil if there's no cache to remove it from)
+	evict func(ce *cacheEntry)
+	// headers set on every response
+	headers map[string]string
+	// true to send X-Content-Type-Options: nosniff
+	nosniff bool
+	// value of the Strict-Transport-Security header sent over HTTPS ("" for none)
+	hsts string
+	// true to apply the headers declared in _headers files
+	headersFile bool
+	// files and directories whose names start with any of these are not served
+	skipPrefixes []string
+	// logger used for all diagnostics
+	logger *slog.Logger
+	// urlpath of the health check ("" for none)
+	healthPath string
+	// true to log every request at accessLogLevel
+	accessLog      bool
+	accessLogLevel slog.Level
+	// called once each request has been served (nil for none)
+	onServe func(ServeEvent)
+	// extension to mime type mappings to register before scanning
+	mimeTypes [][2]string
+	// content types of specific urlpaths, overriding those of their extensions
+	contentTypes map[string]string
+	// additional content types that are never gzipped
+	compressedTypes []string
+	// the only content types that are compressed (nil for any not known to
+	// be compressed already)
+	compressibleTypes []string
+	// returns the Cache-Control value for a urlpath ("" for none)
+	// nil if no Cache-Control headers should be sent
+	cacheControl func(urlpath string) string
+	// if non-nil, the source directory is watched for changes until it is done
+	watchCtx context.Context
+	// true to look up paths ignoring case
+	caseInsensitive bool
+	// true to fail when a path exists in more than one source
+	collisionError bool
+	// true to follow symlinks wherever they lead
+	followSymlinks bool
+	// true to serve .gz and .br sidecars as the compressed variants of their base files
+	precompressed bool
+	// true to read each file on its first request rather than up front (see NewLazyHandler)
+	lazy bool
+	// false to never compress content
+	compression bool
+	// true to check that compressed content decompresses to the original
+	verifyCompression bool
+	// compression level passed to [gzip.NewWriterLevel]
+	gzipLevel int
+	// false to never brotli compress content in process
+	brotli bool
+	// brotli compression level, from 0 (fastest) to 11 (smallest)
+	brotliLevel int
+	// total bytes of compressed content to retain (0 for no limit)
+	compressionBudget int64
+	// compressed content larger than this fraction of the original is not served (0 for any)
+	minCompressionRatio float64
+	// files smaller than this (in bytes) are never compressed
+	minCompressSize int
+	// requests served at once (0 for no limit)
+	maxConcurrentRequests int
+	// what is done with requests beyond maxConcurrentRequests
+	concurrencyMode ConcurrencyMode
+	// files larger than this are read from the source on each request
+	// rather than cached in memory (0 for no limit)
+	maxInMemoryBytes int64
+	// budget (in bytes) of the hot cache (0 for none)
+	hotCacheBytes int64
+	// recently served streamed files - created from hotCacheBytes
+	hotCache *hotCache
+}
+
+// newConfig returns the default config with opts applied in order
+func newConfig(opts []Option) *config {
+	c := &config{
+		indexPath:          defaultWebpath,
+		spaFallback:        true,
+		navigationFallback: true,
+		assetNotFound:      true,
+		skipPrefixes:       []string{".", "_"},
+		compression:        true,
+		gzipLevel:          gzip.BestCompression,
+		brotli:             true,
+		brotliLevel:        defaultBrotliLevel,
+		minCompressSize:    tcpPacketDataSize,
+		nosniff:            true,
+		logger:             slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// returns the key urlpath is cached under
+func (c *config) cacheKey(urlpath string) string {
+	if c.caseInsensitive {
+		return strings.ToLower(urlpath)
+	}
+
+	return urlpath
+}
+
+// Reports whether the file or directory at urlpath should be left out of the cache.
+//
+// The top-level .well-known directory (RFC 8615) is exempt from a broader
+// prefix like "." - it is only skipped if ".well-known" itself is a skip
+// prefix. One nested anywhere else is skipped like any other dotfile.
+func (c *config) shouldSkip(urlpath string) bool {
+	name := path.Base(urlpath)
+	for _, prefix := range c.skipPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if urlpath == "/"+wellKnownDir && prefix != wellKnownDir {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// WithIndexPath sets the urlpath of the SPA fallback that is served
+// for unknown routes (default /index.html), e.g. /200.html or /app.html.
+//
+// The path is relative to the root of the served directory; a leading
+// slash is optional. The handler fails to build if no file exists there.
+func WithIndexPath(urlpath string) Option {
+	return func(c *config) {
+		c.indexPath = path.Clean("/" + urlpath)
+	}
+}
+
+// WithLogger sets the logger used by the handler (default [slog.Default]).
+// A nil logger restores the default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		c.logger = logger
+	}
+}
+
+// WithOnServe calls onServe once each request has been served - including
+// those answered with an error, and those whose client went away mid-response
+// - e.g. to feed metrics without parsing the access log (see [WithAccessLog]).
+// It is called on the request's goroutine, so should be quick.
+func WithOnServe(onServe func(ServeEvent)) Option {
+	return func(c *config) {
+		c.onServe = onServe
+	}
+}
+
+// WithAccessLog logs a record of every request at level through the handler's
+// logger (see [WithLogger]), with its method, path, status, the bytes of body
+// written, how long it took, and the encoding served (identity, gzip, or br).
+func WithAccessLog(level slog.Level) Option {
+	return func(c *config) {
+		c.accessLog = true
+		c.accessLogLevel = level
+	}
+}
+
+// WithHealthPath makes the handler respond to requests for urlpath (e.g.
+// /healthz) with a 200 and a small JSON document - {"status":"ok","routes":N},
+// N being the number of files served - for liveness checks.
+//
+// The health check takes precedence over any file at urlpath, and over
+// maintenance mode (see [Handler.SetMaintenance]).
+func WithHealthPath(urlpath string) Option {
+	return func(c *config) {
+		c.healthPath = path.Clean("/" + urlpath)
+	}
+}
+
+// WithMimeType registers contentType as the mime type for files ending in ext
+// (e.g. WithMimeType(".wasm", "application/wasm")).
+//
+// The handler fails to build if ext does not start with '.' or contentType is
+// not a valid media type. Like [mime.AddExtensionType], the registration is
+// global to the process.
+func WithMimeType(ext string, contentType string) Option {
+	return func(c *config) {
+		c.mimeTypes = append(c.mimeTypes, [2]string{ext, contentType})
+	}
+}
+
+// WithContentTypeOverrides serves the files at the given urlpaths (e.g.
+// /data.txt or /LICENSE) as the given content types, rather than those their
+// extensions map to. Repeated calls add to the set.
+//
+// The handler fails to build if any of the content types is not a valid media type.
+func WithContentTypeOverrides(contentTypes map[string]string) Option {
+	return func(c *config) {
+		if c.contentTypes == nil {
+			c.contentTypes = make(map[string]string, len(contentTypes))
+		}
+
+		for urlpath, contentType := range contentTypes {
+			c.contentTypes[path.Clean("/"+urlpath)] = contentType
+		}
+	}
+}
+
+// WithCompressedTypes adds content types that are already compressed by their
+// format and so are never gzipped (e.g. "application/x-custom-archive").
+// A type ending in '/' (e.g. "model/") matches the whole family.
+//
+// These extend, rather than replace, the built-in list.
+func WithCompressedTypes(contentTypes ...string) Option {
+	return func(c *config) {
+		c.compressedTypes = append(c.compressedTypes, contentTypes...)
+	}
+}
+
+// WithCompressibleTypes compresses only content of the given types (e.g.
+// "application/json"), rather than any that isn't known to be compressed
+// already. A type ending in '/' (e.g. "text/") matches the whole family.
+//
+// This allowlist replaces the built-in list of compressed types entirely, so
+// it can't be combined with [WithCompressedTypes]: the handler fails to build
+// if both are given.
+func WithCompressibleTypes(contentTypes ...string) Option {
+	return func(c *config) {
+		c.compressibleTypes = append(c.compressibleTypes, contentTypes...)
+	}
+}
+
+// WithCacheControl sends Cache-Control: public, max-age=N (N being maxAge in
+// seconds) on assets, and Cache-Control: no-cache on the SPA fallback index,
+// so that clients always revalidate it and pick up new deploys.
+func WithCacheControl(maxAge time.Duration) Option {
+	value := "public, max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	return func(c *config) {
+		c.cacheControl = func(urlpath string) string {
+			if urlpath == c.indexPath {
+				return "no-cache"
+			}
+			return value
+		}
+	}
+}
+
+// WithCacheControlFunc sends the Cache-Control value returned by policy for
+// each urlpath (e.g. "public, max-age=31536000, immutable" for fingerprinted
+// files). No header is sent where policy returns "".
+//
+// policy is called once per file while the handler is built, not per request.
+func WithCacheControlFunc(policy func(urlpath string) string) Option {
+	return func(c *config) {
+		c.cacheControl = policy
+	}
+}
+
+// WithImmutablePattern sends Cache-Control: public, max-age=31536000, immutable
+// on files whose urlpath matches re - fingerprinted assets (e.g. app.abcd1234.js,
+// matched by `\.[0-9a-f]{8,}\.`) whose content never changes under the same
+// name - and Cache-Control: public, max-age=0, must-revalidate on every other
+// file, the index included.
+func WithImmutablePattern(re *regexp.Regexp) Option {
+	return WithCacheControlFunc(func(urlpath string) string {
+		if re.MatchString(urlpath) {
+			return "public, max-age=31536000, immutable"
+		}
+		return "public, max-age=0, must-revalidate"
+	})
+}
+
+// WithMaxInMemoryBytes sets the size above which files are not cached in
+// memory (default 0, meaning every file is cached).
+//
+// Larger files (e.g. videos or big wasm blobs) are instead read from the
+// source directory on each request, and are never served compressed.
+func WithMaxInMemoryBytes(n int64) Option {
+	return func(c *config) {
+		c.maxInMemoryBytes = n
+	}
+}
+
+// WithSkipPrefixes sets the name prefixes of files and directories that are
+// not served, replacing the default of "." and "_" (hidden files and e.g.
+// _drafts). Calling it with no prefixes disables skipping entirely.
+//
+// The .well-known directory is served unless ".well-known" is given explicitly.
+func WithSkipPrefixes(prefixes ...string) Option {
+	return func(c *config) {
+		c.skipPrefixes = prefixes
+	}
+}
+
+// WithNotFoundPage serves the file at urlpath (e.g. /404.html) with a 404
+// status when a requested asset doesn't exist, rather than an empty 404.
+// Like any other file, it is served compressed where the client allows.
+//
+// The handler fails to build if no file exists at urlpath.
+func WithNotFoundPage(urlpath string) Option {
+	return func(c *config) {
+		c.notFoundPath = path.Clean("/" + urlpath)
+	}
+}
+
+// WithAlias serves the file at target (e.g. /index.html) for requests for
+// urlpath (e.g. /login) that no file exists at. Combined with
+// WithSPAFallback(false), this allows routing only a known set of client-side
+// routes to the index, so that any other path gets a 404.
+//
+// The handler fails to build if no file exists at target.
+func WithAlias(urlpath string, target string) Option {
+	return func(c *config) {
+		if c.aliases == nil {
+			c.aliases = make(map[string]string)
+		}
+
+		c.aliases[path.Clean("/"+urlpath)] = path.Clean("/" + target)
+	}
+}
+
+// WithPreload makes responses serving the index (including the SPA fallback)
+// tell browsers to start fetching the files at urlpaths right away, with a
+// Link header (e.g. Link: </app.js>; rel=preload; as=script) for each. The
+// kind of file (script, style, font, ...) is inferred from its content type.
+//
+// The handler fails to build if any of the files doesn't exist, or is of a
+// type that can't be preloaded. Repeated calls add to the list.
+func WithPreload(urlpaths ...string) Option {
+	return func(c *config) {
+		for _, urlpath := range urlpaths {
+			c.preload = append(c.preload, path.Clean("/"+urlpath))
+		}
+	}
+}
+
+// WithServerErrorPage serves the file at urlpath (e.g. /500.html) with a 500
+// status when the handler fails to serve a request (e.g. a file too large to
+// cache can't be read). Should serving the page fail too, a plain text 500 is
+// written. It takes precedence over [WithErrorHandler] for 500s.
+//
+// The handler fails to build if no file exists at urlpath.
+func WithServerErrorPage(urlpath string) Option {
+	return func(c *config) {
+		c.serverErrorPath = path.Clean("/" + urlpath)
+	}
+}
+
+// WithHTMLTransform rewrites every text/html response (e.g. the index, or
+// the 404 page) with transform before it is served - for instance, to inject
+// a <base href> or a CSP nonce that depends on the request. transform is
+// passed the cached content, which it must not modify; it returns the HTML
+// to serve instead.
+//
+// This has a cost: transformed responses are hashed for their ETag on every
+// request, and are served uncompressed (compressing them each time would
+// cost far more than it saves for pages this size), without Last-Modified.
+// Streamed files (see [WithMaxInMemoryBytes]) are served as they are.
+func WithHTMLTransform(transform func(r *http.Request, html []byte) []byte) Option {
+	return func(c *config) {
+		c.htmlTransform = transform
+	}
+}
+
+// WithDefaultRobots serves content as /robots.txt if no such file exists
+// (e.g. "User-agent: *\nDisallow:\n"), so that crawlers get a real answer
+// rather than a 404 - or, worse, the index.
+func WithDefaultRobots(content string) Option {
+	return func(c *config) {
+		c.defaultRobots = []byte(content)
+	}
+}
+
+// WithDefaultFavicon serves icon as /favicon.ico if no such file exists, so
+// that browsers requesting it get a real answer rather than a 404.
+func WithDefaultFavicon(icon []byte) Option {
+	return func(c *config) {
+		c.defaultFavicon = icon
+	}
+}
+
+// WithPathPrefix mounts the handler under prefix (e.g. /app): the prefix is
+// trimmed from request paths before they are looked up, and requests outside
+// of it get a 404. Unlike wrapping with [http.StripPrefix], unknown routes
+// under the prefix still get the SPA fallback.
+func WithPathPrefix(prefix string) Option {
+	return func(c *config) {
+		c.pathPrefix = strings.TrimSuffix(path.Clean("/"+prefix), "/")
+	}
+}
+
+// WithGzipLevel sets the level content is gzipped at (default
+// [gzip.BestCompression]); any level accepted by [gzip.NewWriterLevel] is valid.
+//
+// Content is compressed once, while the handler is built, so the default
+// trades startup time for the smallest gzipped responses; a lower level like
+// [gzip.BestSpeed] gzips faster at the cost of somewhat larger ones. Only the
+// gzip variant is affected - content is brotli compressed as well, at its own
+// level (see [WithBrotliLevel]), so to cut startup time for large trees (or
+// frequent reloads), lower both or disable brotli with [WithBrotli].
+func WithGzipLevel(level int) Option {
+	return func(c *config) {
+		c.gzipLevel = level
+	}
+}
+
+// WithBrotliLevel sets the level content is brotli compressed at (default 5),
+// from 0 (fastest) to 11 (smallest).
+//
+// Brotli's highest levels are far slower than gzip's for little further
+// saving, so the default is a moderate one; raising it mostly lengthens the
+// handler's startup (and each lazily read file's first request).
+func WithBrotliLevel(level int) Option {
+	return func(c *config) {
+		c.brotliLevel = level
+	}
+}
+
+// WithBrotli sets whether content is brotli compressed in process (default
+// true), independently of gzip - disabling it skips the slower of the two
+// while still serving gzip. Precompressed .br sidecars (see [WithPrecompressed])
+// are still served.
+func WithBrotli(enabled bool) Option {
+	return func(c *config) {
+		c.brotli = enabled
+	}
+}
+
+// WithCompression sets whether content is compressed at all (default true).
+//
+// Disabling it (e.g. when a proxy in front of the handler already compresses
+// responses) skips compressing while the handler is built - cutting its startup
+// time and memory - and every response is then served as is.
+func WithCompression(enabled bool) Option {
+	return func(c *config) {
+		c.compression = enabled
+	}
+}
+
+// WithPrecompressed serves files ending in .gz and .br (e.g. app.js.gz and
+// app.js.br, as produced by many build pipelines) as the gzip and brotli
+// encoded variants of the file they sit next to (app.js), rather than as
+// routes of their own. The sidecars are used in place of compressing in
+// process, even where [WithCompression] disables it.
+//
+// A sidecar without a base file is skipped with a warning - so any .gz or .br
+// files meant to be downloaded as is (e.g. a .tar.gz) are no longer served.
+func WithPrecompressed() Option {
+	return func(c *config) {
+		c.precompressed = true
+	}
+}
+
+// WithVerifyCompression makes the handler decompress each compressed variant
+// it builds and check that it matches the original content - guarding against
+// ever serving garbage, at the cost of a slower startup. Content that fails
+// the check is logged as an error and only served uncompressed.
+func WithVerifyCompression() Option {
+	return func(c *config) {
+		c.verifyCompression = true
+	}
+}
+
+// WithCompressionBudget bounds the memory spent on compressed variants: once
+// they total maxTotalBytes, no more are kept, and the remaining files are only
+// served uncompressed. Text files (which compress best) are compressed first,
+// smallest first, so the budget covers as many of them as it can.
+func WithCompressionBudget(maxTotalBytes int64) Option {
+	return func(c *config) {
+		c.compressionBudget = maxTotalBytes
+	}
+}
+
+// WithMinCompressionRatio only serves compressed content that is at most
+// ratio times the size of the original (e.g. 0.9 for a saving of at least 10%),
+// so that files which barely compress are served as is.
+//
+// This is in addition to the built-in rule that compressing must save at least
+// one TCP packet (1460 bytes) - both must pass. The handler fails to build
+// if ratio is not between 0 and 1.
+func WithMinCompressionRatio(ratio float64) Option {
+	return func(c *config) {
+		c.minCompressionRatio = ratio
+	}
+}
+
+// WithMinCompressSize sets the size (in bytes) below which files are never
+// compressed (default 1460, the data in one TCP packet). Compressing tiny
+// files wastes startup CPU, and their compressed form is often larger anyway.
+func WithMinCompressSize(n int) Option {
+	return func(c *config) {
+		c.minCompressSize = n
+	}
+}
+
+// TrailingSlashMode selects how [WithTrailingSlashRedirect] normalizes paths
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashStrip redirects /about/ to /about
+	TrailingSlashStrip TrailingSlashMode = iota + 1
+	// TrailingSlashAdd redirects /docs to /docs/ for directories with an index.html
+	TrailingSlashAdd
+)
+
+// WithTrailingSlashRedirect permanently (301) redirects requests whose path
+// differs from its canonical form only by a trailing slash, so that each page
+// has a single URL. The query string is preserved.
+//
+// Redirects only happen for paths that exist in the cache;
+// anything else falls through to the usual SPA handling.
+func WithTrailingSlashRedirect(mode TrailingSlashMode) Option {
+	return func(c *config) {
+		c.trailingSlash = mode
+	}
+}
+
+// WithCanonicalRedirect permanently (301) redirects requests for files whose
+// path isn't in its canonical form - e.g. //assets//app.js or /a/./b.js - to
+// that form, so that caches and analytics see a single URL for each file.
+// Any trailing slash (see [WithTrailingSlashRedirect]) and the query string
+// are preserved. Without it, such paths are served as if they were canonical.
+func WithCanonicalRedirect() Option {
+	return func(c *config) {
+		c.canonicalRedirect = true
+	}
+}
+
+// WithDirectoryListing serves a generated HTML listing of the files in a
+// directory (like [http.FileServer] does) for requests for a directory without
+// an index.html - rather than the SPA fallback or a 404. It is meant for
+// plain static file serving (see [WithSPAFallback]); as it reveals the names
+// of every file served, it is off by default.
+func WithDirectoryListing() Option {
+	return func(c *config) {
+		c.directoryListing = true
+	}
+}
+
+// WithDebugEncodingParam lets requests force the encoding they are served
+// with - regardless of their Accept-Encoding - with the query parameter name,
+// e.g. ?enc=identity, ?enc=gzip, or ?enc=br with name "enc". This helps to
+// debug e.g. a CDN mangling compressed responses. Unknown values are ignored,
+// as are encodings a file isn't available in.
+//
+// Forced responses are sent with Cache-Control: no-store. Still, as it lets
+// anyone pick what is served, it is off by default and meant for debugging.
+func WithDebugEncodingParam(name string) Option {
+	return func(c *config) {
+		c.debugEncodingParam = name
+	}
+}
+
+// WithHeaders sets the given headers (e.g. Content-Security-Policy or
+// Referrer-Policy) on every response the handler writes - including
+// 304s, 404s, and other errors. Repeated calls add to the set.
+func WithHeaders(headers map[string]string) Option {
+	return func(c *config) {
+		if c.headers == nil {
+			c.headers = make(map[string]string, len(headers))
+		}
+
+		for name, value := range headers {
+			c.headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+}
+
+// WithHeadersFile applies the headers declared in a Netlify-style _headers
+// file at the root of the served directory, e.g.
+//
+//	/assets/*
+//	  Cache-Control: public, max-age=31536000, immutable
+//	/admin/*
+//	  Content-Security-Policy: default-src 'self'
+//
+// Rules are matched against the request path (within any [WithPathPrefix]),
+// so a rule also applies to unknown routes served the SPA fallback. A ":name"
+// segment matches any one segment, and a final "*" matches whatever follows.
+// Where several rules set the same header, the last one wins.
+//
+// The handler fails to build if the file is malformed. It is not served itself
+// (unless "_" is no longer a skip prefix, see [WithSkipPrefixes]).
+func WithHeadersFile() Option {
+	return func(c *config) {
+		c.headersFile = true
+	}
+}
+
+// WithoutNosniff stops the handler from sending X-Content-Type-Options: nosniff,
+// which it otherwise does on every response to prevent browsers from
+// MIME-sniffing assets into a more dangerous type.
+func WithoutNosniff() Option {
+	return func(c *config) {
+		c.nosniff = false
+	}
+}
+
+// WithHSTS sends a Strict-Transport-Security header, telling browsers to only
+// ever use HTTPS for the site for maxAge (e.g. a year), and optionally for its
+// subdomains too and with the site's consent to being on browsers' preload list.
+//
+// The header is only sent on responses to requests that arrived over HTTPS -
+// directly, or through a proxy setting X-Forwarded-Proto: https - as browsers
+// ignore it over plain HTTP.
+func WithHSTS(maxAge time.Duration, includeSubdomains bool, preload bool) Option {
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+
+	return func(c *config) {
+		c.hsts = value
+	}
+}
+
+// WithFollowSymlinks sets whether symlinks found while scanning a directory
+// are followed wherever they lead (default false).
+//
+// By default, symlinks to files within the served directory are served,
+// symlinks to directories within it are skipped, and the handler fails to
+// build if any symlink resolves outside of it - so a stray link can't expose
+// e.g. /etc. When following, symlinks that would loop back into a directory
+// being scanned are skipped.
+func WithFollowSymlinks(follow bool) Option {
+	return func(c *config) {
+		c.followSymlinks = follow
+	}
+}
+
+// WithSPAFallback sets whether unknown routes are served the index
+// (default true). Disabling it turns the handler into a plain static file
+// server that responds 404 to any path it doesn't have a file for.
+func WithSPAFallback(enabled bool) Option {
+	return func(c *config) {
+		c.spaFallback = enabled
+	}
+}
+
+// WithNavigationFallback sets whether the SPA fallback is only served to
+// navigation requests (default true): those with Sec-Fetch-Mode: navigate or,
+// from clients that don't send it, an Accept header listing text/html. Other
+// requests for unknown routes - e.g. a fetch expecting JSON - get a 404, rather
+// than the index's HTML and a confusing parse error.
+//
+// Disabling it serves the fallback to every request for an unknown route.
+func WithNavigationFallback(enabled bool) Option {
+	return func(c *config) {
+		c.navigationFallback = enabled
+	}
+}
+
+// WithAssetNotFound sets whether requests for unknown paths with a file
+// extension (e.g. /app.js) get a 404 rather than the SPA fallback (default
+// true), so that a missing asset surfaces as such in the browser console.
+//
+// Navigation requests (see [WithNavigationFallback]) still get the fallback,
+// as client-side routes may contain a dot (e.g. /users/jane.doe).
+func WithAssetNotFound(enabled bool) Option {
+	return func(c *config) {
+		c.assetNotFound = enabled
+	}
+}
+
+// WithErrorHandler sets the function that writes the response whenever a
+// request can't be served - e.g. with a 400, 404, 405, 406, or 500 status -
+// so that errors can be rendered as a branded page or a JSON problem document.
+//
+// The handler must write status itself. By default, the bare status is written
+// (with a short plain text explanation for a 500). A page configured with
+// [WithNotFoundPage] takes precedence for missing assets.
+func WithErrorHandler(handler func(wr http.ResponseWriter, r *http.Request, status int)) Option {
+	return func(c *config) {
+		c.errorHandler = handler
+	}
+}
+
+// WithCaseInsensitivePaths looks up request paths ignoring case, so that e.g.
+// a request for /logo.png is served Logo.PNG - as it would be by a
+// case-insensitive filesystem (like those of macOS and Windows) in development.
+//
+// Files whose paths only differ by case collide; only one of them is served,
+// with a warning logged when the handler is built.
+func WithCaseInsensitivePaths() Option {
+	return func(c *config) {
+		c.caseInsensitive = true
+	}
+}
+
+// WithCollisionError makes a handler serving multiple directories (see
+// [NewHandlerMulti]) fail to build when the same path exists in more than one
+// of them, rather than letting the later directory win.
+func WithCollisionError() Option {
+	return func(c *config) {
+		c.collisionError = true
+	}
+}
+
+// WithMaxConcurrentRequests limits the handler to serving n requests at once,
+// so that a traffic spike can't e.g. saturate memory bandwidth. What happens to
+// requests beyond the limit depends on mode.
+//
+// Health checks (see [WithHealthPath]) aren't limited.
+func WithMaxConcurrentRequests(n int, mode ConcurrencyMode) Option {
+	return func(c *config) {
+		c.maxConcurrentRequests = n
+		c.concurrencyMode = mode
+	}
+}
+
+// WithHotCache keeps the content of recently served large files (see
+// [WithMaxInMemoryBytes]) in memory, up to a total of maxBytes, so that
+// popular ones aren't re-read from the source on every request. The least
+// recently used files are evicted once the budget is exceeded.
+func WithHotCache(maxBytes int64) Option {
+	return func(c *config) {
+		c.hotCacheBytes = maxBytes
+	}
+}
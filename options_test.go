@@ -0,0 +1,827 @@
+package spa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestOptionsLaterWins(t *testing.T) {
+	files := map[string]string{
+		"index.html":    "<html>index</html>",
+		"fallback.html": "<html>fallback</html>",
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"index first", []Option{WithIndexPath("/index.html"), WithIndexPath("/fallback.html")}, files["fallback.html"]},
+		{"fallback first", []Option{WithIndexPath("/fallback.html"), WithIndexPath("/index.html")}, files["index.html"]},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+		if wr := serve(h, http.MethodGet, "/some/route", "Accept", "text/html"); wr.Body.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, wr.Body.String(), tt.want)
+		}
+	}
+
+	// including the logger, which newTestHandler sets first
+	rec := &recordingHandler{}
+	newTestHandler(t, files, WithLogger(discardLogger), WithLogger(slog.New(rec)))
+	if _, _, ok := rec.find("spa: handler ready"); !ok {
+		t.Error("the later logger wasn't used")
+	}
+
+	h := newTestHandler(t, files, WithCacheControl(time.Hour), WithCacheControl(time.Minute))
+	if got := serve(h, http.MethodGet, "/fallback.html").Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("got Cache-Control %q, want public, max-age=60", got)
+	}
+}
+
+func TestWithIndexPath(t *testing.T) {
+	files := map[string]string{"fallback.html": "<html>fallback</html>"}
+	h := newTestHandler(t, files, WithIndexPath("/fallback.html"))
+
+	wr := serve(h, http.MethodGet, "/some/route", "Accept", "text/html")
+	if wr.Code != http.StatusOK || wr.Body.String() != files["fallback.html"] {
+		t.Errorf("got status %d with %q, want %d with the fallback", wr.Code, wr.Body.String(), http.StatusOK)
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithIndexPath("/app.html"))
+	if err == nil || !strings.Contains(err.Error(), "/app.html") {
+		t.Errorf("got error %v, want one naming the missing /app.html", err)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	files := map[string]string{
+		"index.html":      "<html></html>",
+		"app.js":          "console.log(1)",
+		"app.a1b2c3d4.js": "console.log(2)",
+	}
+
+	// fingerprinted files are immutable, and the rest get no header
+	policy := func(urlpath string) string {
+		if strings.Contains(urlpath, ".a1b2c3d4.") {
+			return "public, max-age=31536000, immutable"
+		}
+		return ""
+	}
+	fingerprint := regexp.MustCompile(`\.[0-9a-f]{8,}\.`)
+
+	for _, tt := range []struct {
+		name   string
+		opts   []Option
+		path   string
+		accept string
+		want   string
+	}{
+		{"none by default", nil, "/app.js", "", ""},
+		{"asset", []Option{WithCacheControl(time.Hour)}, "/app.js", "", "public, max-age=3600"},
+		{"index", []Option{WithCacheControl(time.Hour)}, "/index.html", "", "no-cache"},
+		{"fallback index", []Option{WithCacheControl(time.Hour)}, "/some/route", "text/html", "no-cache"},
+		{"custom fingerprinted", []Option{WithCacheControlFunc(policy)}, "/app.a1b2c3d4.js", "", "public, max-age=31536000, immutable"},
+		{"custom other", []Option{WithCacheControlFunc(policy)}, "/app.js", "", ""},
+		{"immutable fingerprinted", []Option{WithImmutablePattern(fingerprint)}, "/app.a1b2c3d4.js", "", "public, max-age=31536000, immutable"},
+		{"immutable other", []Option{WithImmutablePattern(fingerprint)}, "/app.js", "", "public, max-age=0, must-revalidate"},
+		{"immutable index", []Option{WithImmutablePattern(fingerprint)}, "/index.html", "", "public, max-age=0, must-revalidate"},
+		{"immutable fallback", []Option{WithImmutablePattern(fingerprint)}, "/some/route", "text/html", "public, max-age=0, must-revalidate"},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		wr := serve(h, http.MethodGet, tt.path, "Accept", tt.accept)
+		if wr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, http.StatusOK)
+		}
+		if got := wr.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: got Cache-Control %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSkipPrefixes(t *testing.T) {
+	files := map[string]string{
+		"index.html":                             "<html></html>",
+		".env":                                   "SECRET=1",
+		"_drafts/post.html":                      "<html>draft</html>",
+		"~backup.js":                             "old",
+		".well-known/acme-challenge/_-token1234": "token",
+	}
+
+	for _, tt := range []struct {
+		name    string
+		opts    []Option
+		served  []string
+		skipped []string
+	}{
+		{
+			name:    "default",
+			served:  []string{"/~backup.js", "/.well-known/acme-challenge/_-token1234"},
+			skipped: []string{"/.env", "/_drafts/post.html"},
+		},
+		{
+			name:    "custom",
+			opts:    []Option{WithSkipPrefixes("~")},
+			served:  []string{"/.env", "/_drafts/post.html", "/.well-known/acme-challenge/_-token1234"},
+			skipped: []string{"/~backup.js"},
+		},
+		{
+			name:   "none",
+			opts:   []Option{WithSkipPrefixes()},
+			served: []string{"/.env", "/_drafts/post.html", "/~backup.js", "/.well-known/acme-challenge/_-token1234"},
+		},
+		{
+			name:    "well-known too",
+			opts:    []Option{WithSkipPrefixes(".", ".well-known")},
+			served:  []string{"/_drafts/post.html"},
+			skipped: []string{"/.env", "/.well-known/acme-challenge/_-token1234"},
+		},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		for _, urlpath := range tt.served {
+			wr := serve(h, http.MethodGet, urlpath)
+			if wr.Code != http.StatusOK || wr.Body.String() != files[urlpath[1:]] {
+				t.Errorf("%s: %s got status %d with %q, want the file", tt.name, urlpath, wr.Code, wr.Body.String())
+			}
+		}
+		for _, urlpath := range tt.skipped {
+			if _, ok := h.entries()[urlpath]; ok {
+				t.Errorf("%s: %s is cached, want it skipped", tt.name, urlpath)
+			}
+		}
+	}
+}
+
+func TestWellKnown(t *testing.T) {
+	files := map[string]string{
+		"index.html":                "<html></html>",
+		".well-known/security.txt":  "Contact: mailto:security@example.com",
+		".well-known/.hidden":       "served as is",
+		"vendor/.well-known/secret": "a dotfile like any other",
+		"vendor/.git/config":        "[core]",
+	}
+	h := newTestHandler(t, files)
+
+	for _, urlpath := range []string{"/.well-known/security.txt", "/.well-known/.hidden"} {
+		wr := serve(h, http.MethodGet, urlpath)
+		if wr.Code != http.StatusOK || wr.Body.String() != files[urlpath[1:]] {
+			t.Errorf("%s: got status %d with %q, want the file", urlpath, wr.Code, wr.Body.String())
+		}
+	}
+
+	for _, urlpath := range []string{"/vendor/.well-known/secret", "/vendor/.git/config"} {
+		if _, ok := h.entries()[urlpath]; ok {
+			t.Errorf("%s is cached, want it skipped", urlpath)
+		}
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"404.html":   "<html>not found</html>" + compressible,
+	}
+	h := newTestHandler(t, files, WithNotFoundPage("/404.html"))
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		wr := serve(h, http.MethodGet, "/does-not-exist.js", "Accept", "*/*", "Accept-Encoding", acceptEncoding)
+		if wr.Code != http.StatusNotFound {
+			t.Errorf("%q: got status %d, want %d", acceptEncoding, wr.Code, http.StatusNotFound)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != acceptEncoding {
+			t.Errorf("%q: got Content-Encoding %q", acceptEncoding, got)
+		}
+		if got := decode(t, acceptEncoding, wr.Body); got != files["404.html"] {
+			t.Errorf("%q: got %q, want the 404 page", acceptEncoding, got)
+		}
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithNotFoundPage("/missing.html"))
+	if err == nil || !strings.Contains(err.Error(), "/missing.html") {
+		t.Errorf("got error %v, want one naming the missing /missing.html", err)
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"app.js":     "console.log(1)",
+	}
+	h := newTestHandler(t, files, WithPathPrefix("/app/"))
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/app/", http.StatusOK, files["index.html"]},
+		{"/app", http.StatusOK, files["index.html"]},
+		{"/app/foo", http.StatusOK, files["index.html"]},
+		{"/app/app.js", http.StatusOK, files["app.js"]},
+		{"/app.js", http.StatusNotFound, ""},
+		{"/application", http.StatusNotFound, ""},
+		{"/other/foo", http.StatusNotFound, ""},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, wr.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && wr.Body.String() != tt.body {
+			t.Errorf("%s: got %q, want %q", tt.path, wr.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	files := map[string]string{
+		"index.html":      "<html></html>",
+		"about":           "<html>about</html>",
+		"docs/index.html": "<html>docs</html>",
+	}
+
+	for _, tt := range []struct {
+		name     string
+		mode     TrailingSlashMode
+		path     string
+		status   int
+		location string
+	}{
+		{"strip", TrailingSlashStrip, "/about/", http.StatusMovedPermanently, "/about"},
+		{"strip with query", TrailingSlashStrip, "/about/?tab=team&x=1", http.StatusMovedPermanently, "/about?tab=team&x=1"},
+		{"strip canonical", TrailingSlashStrip, "/about", http.StatusOK, ""},
+		{"strip unknown", TrailingSlashStrip, "/contact/", http.StatusOK, ""},
+		{"add", TrailingSlashAdd, "/docs", http.StatusMovedPermanently, "/docs/"},
+		{"add with query", TrailingSlashAdd, "/docs?v=2", http.StatusMovedPermanently, "/docs/?v=2"},
+		{"add canonical", TrailingSlashAdd, "/docs/", http.StatusOK, ""},
+		{"add file", TrailingSlashAdd, "/about", http.StatusOK, ""},
+		{"add unknown", TrailingSlashAdd, "/contact", http.StatusOK, ""},
+	} {
+		h := newTestHandler(t, files, WithTrailingSlashRedirect(tt.mode))
+
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, tt.status)
+		}
+		if got := wr.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: got Location %q, want %q", tt.name, got, tt.location)
+		}
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	const csp = "default-src 'self'"
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithHeaders(map[string]string{
+		"Content-Security-Policy": csp,
+		"Referrer-Policy":         "no-referrer",
+	}))
+
+	ok := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", "gzip")
+	notModified := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", "gzip", "If-None-Match", ok.Header().Get("ETag"))
+	notFound := serve(h, http.MethodGet, "/missing.js", "Accept", "*/*")
+
+	for _, tt := range []struct {
+		name   string
+		wr     *httptest.ResponseRecorder
+		status int
+	}{
+		{"200", ok, http.StatusOK},
+		{"304", notModified, http.StatusNotModified},
+		{"404", notFound, http.StatusNotFound},
+	} {
+		if tt.wr.Code != tt.status {
+			t.Errorf("%s: got status %d", tt.name, tt.wr.Code)
+		}
+		if got := tt.wr.Header().Get("Content-Security-Policy"); got != csp {
+			t.Errorf("%s: got Content-Security-Policy %q, want %q", tt.name, got, csp)
+		}
+		if got := tt.wr.Header().Get("Referrer-Policy"); got != "no-referrer" {
+			t.Errorf("%s: got Referrer-Policy %q, want no-referrer", tt.name, got)
+		}
+	}
+}
+
+func TestNosniff(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"app.js":     "console.log(1)",
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "nosniff"},
+		{"without", []Option{WithoutNosniff()}, ""},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		for _, urlpath := range []string{"/index.html", "/app.js", "/missing.js"} {
+			wr := serve(h, http.MethodGet, urlpath, "Accept", "*/*")
+			if got := wr.Header().Get("X-Content-Type-Options"); got != tt.want {
+				t.Errorf("%s: %s got X-Content-Type-Options %q, want %q", tt.name, urlpath, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSPAFallbackDisabled(t *testing.T) {
+	files := map[string]string{"index.html": "<html></html>"}
+	h := newTestHandler(t, files, WithSPAFallback(false))
+
+	for _, accept := range []string{"text/html", "*/*"} {
+		if wr := serve(h, http.MethodGet, "/missing", "Accept", accept); wr.Code != http.StatusNotFound {
+			t.Errorf("/missing (%s): got status %d, want %d", accept, wr.Code, http.StatusNotFound)
+		}
+	}
+
+	if wr := serve(h, http.MethodGet, "/index.html"); wr.Code != http.StatusOK || wr.Body.String() != files["index.html"] {
+		t.Errorf("/index.html: got status %d with %q, want the index", wr.Code, wr.Body.String())
+	}
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	var statuses []int
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"}, WithErrorHandler(func(wr http.ResponseWriter, r *http.Request, status int) {
+		statuses = append(statuses, status)
+		wr.Header().Set("Content-Type", "application/problem+json")
+		wr.WriteHeader(status)
+		wr.Write([]byte(`{"status":` + strconv.Itoa(status) + `}`))
+	}))
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/missing.js", http.StatusNotFound},
+		{http.MethodPost, "/index.html", http.StatusMethodNotAllowed},
+	} {
+		statuses = nil
+
+		wr := serve(h, tt.method, tt.path, "Accept", "*/*")
+		if !slices.Equal(statuses, []int{tt.status}) {
+			t.Errorf("%s %s: error handler called with %v, want [%d]", tt.method, tt.path, statuses, tt.status)
+		}
+		if wr.Code != tt.status || wr.Body.String() != `{"status":`+strconv.Itoa(tt.status)+`}` {
+			t.Errorf("%s %s: got status %d with %q, want the error handler's response", tt.method, tt.path, wr.Code, wr.Body.String())
+		}
+	}
+
+	// successful responses don't go through it
+	statuses = nil
+	if serve(h, http.MethodGet, "/index.html"); len(statuses) != 0 {
+		t.Errorf("error handler called with %v for a 200", statuses)
+	}
+}
+
+func TestNavigationFallback(t *testing.T) {
+	files := map[string]string{"index.html": "<html></html>"}
+
+	for _, tt := range []struct {
+		name    string
+		opts    []Option
+		headers []string
+		status  int
+	}{
+		{"html", nil, []string{"Accept", "text/html,application/xhtml+xml,*/*;q=0.8"}, http.StatusOK},
+		{"navigate", nil, []string{"Sec-Fetch-Mode", "navigate", "Accept", "*/*"}, http.StatusOK},
+		{"json", nil, []string{"Accept", "application/json"}, http.StatusNotFound},
+		{"fetch", nil, []string{"Sec-Fetch-Mode", "cors", "Accept", "*/*"}, http.StatusNotFound},
+		{"json without navigation fallback", []Option{WithNavigationFallback(false)}, []string{"Accept", "application/json"}, http.StatusOK},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		wr := serve(h, http.MethodGet, "/users/42", tt.headers...)
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && wr.Body.String() != files["index.html"] {
+			t.Errorf("%s: got %q, want the index", tt.name, wr.Body.String())
+		}
+
+		// the response depends on these, so caches must key on them
+		vary := wr.Header().Values("Vary")
+		navigationAware := len(tt.opts) == 0
+		if got := slices.Contains(vary, "Accept, Sec-Fetch-Mode"); got != navigationAware {
+			t.Errorf("%s: got Vary %q", tt.name, vary)
+		}
+	}
+}
+
+func TestContentTypeOverrides(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		"data.txt":   "{\"a\":1}\n{\"a\":2}\n",
+		"notes.txt":  "plain",
+	}, WithContentTypeOverrides(map[string]string{"data.txt": "application/x-ndjson"}))
+
+	for urlpath, want := range map[string]string{
+		"/data.txt":  "application/x-ndjson",
+		"/notes.txt": "text/plain; charset=utf-8",
+	} {
+		if got := serve(h, http.MethodGet, urlpath).Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: got Content-Type %q, want %q", urlpath, got, want)
+		}
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, map[string]string{"index.html": ""}), WithLogger(discardLogger), WithContentTypeOverrides(map[string]string{"/index.html": "text/"}))
+	if err == nil {
+		t.Error("got no error for an invalid content type")
+	}
+}
+
+func TestCaseInsensitivePaths(t *testing.T) {
+	files := map[string]string{
+		"index.html":      "<html></html>",
+		"img/Logo.PNG":    "\x89PNG logo",
+		"docs/README.md":  "upper",
+		"docs/readme.md":  "lower",
+		"assets/App.JS":   "console.log(1)",
+		"assets/other.js": "console.log(2)",
+	}
+
+	h := newTestHandler(t, files)
+	if wr := serve(h, http.MethodGet, "/assets/app.js"); wr.Body.String() == files["assets/App.JS"] {
+		t.Error("case-sensitive: /assets/app.js served /assets/App.JS")
+	}
+
+	rec := &recordingHandler{}
+	h = newTestHandler(t, files, WithCaseInsensitivePaths(), WithLogger(slog.New(rec)))
+
+	for urlpath, want := range map[string]string{
+		"/img/logo.png":  files["img/Logo.PNG"],
+		"/IMG/LOGO.PNG":  files["img/Logo.PNG"],
+		"/assets/app.js": files["assets/App.JS"],
+		"/Index.HTML":    files["index.html"],
+	} {
+		wr := serve(h, http.MethodGet, urlpath)
+		if wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %q", urlpath, wr.Code, wr.Body.String(), want)
+		}
+	}
+
+	// the original name still decides the content type
+	if got := serve(h, http.MethodGet, "/img/logo.png").Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("got Content-Type %q, want image/png", got)
+	}
+
+	_, attrs, ok := rec.find("spa: paths collide ignoring case, only one is served")
+	if !ok {
+		t.Fatal("got no warning for colliding paths")
+	}
+	if got := []string{attrs["path"].String(), attrs["other"].String()}; !slices.Contains(got, "/docs/README.md") || !slices.Contains(got, "/docs/readme.md") {
+		t.Errorf("got warning for %q, want /docs/README.md and /docs/readme.md", got)
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	files := map[string]string{"index.html": "<html></html>"}
+
+	for _, tt := range []struct {
+		maxAge            time.Duration
+		includeSubdomains bool
+		preload           bool
+		want              string
+	}{
+		{365 * 24 * time.Hour, false, false, "max-age=31536000"},
+		{365 * 24 * time.Hour, true, false, "max-age=31536000; includeSubDomains"},
+		{2 * 365 * 24 * time.Hour, true, true, "max-age=63072000; includeSubDomains; preload"},
+		{90 * time.Second, false, true, "max-age=90; preload"},
+		{0, false, false, "max-age=0"},
+	} {
+		h := newTestHandler(t, files, WithHSTS(tt.maxAge, tt.includeSubdomains, tt.preload))
+
+		if got := serve(h, http.MethodGet, "https://example.com/").Header().Get("Strict-Transport-Security"); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	h := newTestHandler(t, files, WithHSTS(365*24*time.Hour, true, false))
+	etag := serve(h, http.MethodGet, "/").Header().Get("ETag")
+
+	for _, tt := range []struct {
+		name    string
+		target  string
+		headers []string
+		want    bool
+	}{
+		{"tls", "https://example.com/", nil, true},
+		{"tls not modified", "https://example.com/", []string{"If-None-Match", etag}, true},
+		{"proxied tls", "/", []string{"X-Forwarded-Proto", "https"}, true},
+		{"proxied tls uppercase", "/", []string{"X-Forwarded-Proto", "HTTPS"}, true},
+		{"plain http", "/", nil, false},
+		{"proxied plain http", "/", []string{"X-Forwarded-Proto", "http"}, false},
+	} {
+		wr := serve(h, http.MethodGet, tt.target, tt.headers...)
+		if got := wr.Header().Get("Strict-Transport-Security") != ""; got != tt.want {
+			t.Errorf("%s: got Strict-Transport-Security %q (status %d)", tt.name, wr.Header().Get("Strict-Transport-Security"), wr.Code)
+		}
+	}
+
+	// off by default
+	h = newTestHandler(t, files)
+	if got := serve(h, http.MethodGet, "https://example.com/").Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("default: got %q, want none", got)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"pricing":    "a file named like the route",
+		"about.html": "<html>about</html>",
+	}
+	h := newTestHandler(t, files,
+		WithSPAFallback(false),
+		WithAlias("/dashboard", "/index.html"),
+		WithAlias("settings/", "index.html"),
+		WithAlias("/team", "/about.html"),
+		WithAlias("/login", "/index.html"),
+		WithAlias("/pricing", "/index.html"))
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/login", http.StatusOK, files["index.html"]},
+		{"/dashboard", http.StatusOK, files["index.html"]},
+		{"/settings", http.StatusOK, files["index.html"]},
+		{"/team", http.StatusOK, files["about.html"]},
+		// a file at the path is served instead
+		{"/pricing", http.StatusOK, files["pricing"]},
+		{"/nope.js", http.StatusNotFound, ""},
+		{"/unknown/route", http.StatusNotFound, ""},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != tt.status || (tt.body != "" && wr.Body.String() != tt.body) {
+			t.Errorf("%s: got status %d with %q, want %d with %q", tt.path, wr.Code, wr.Body.String(), tt.status, tt.body)
+		}
+	}
+
+	_, err := NewHandlerWithOptions(writeTree(t, files), WithLogger(discardLogger), WithAlias("/login", "/missing.html"))
+	if err == nil {
+		t.Error("got no error for an alias to a missing file")
+	}
+}
+
+func TestCanonicalRedirect(t *testing.T) {
+	files := map[string]string{
+		"index.html":    "<html></html>",
+		"assets/app.js": "console.log(1)",
+	}
+
+	// served as if they were canonical by default
+	h := newTestHandler(t, files)
+	for target, want := range map[string]string{
+		"//index.html":      files["index.html"],
+		"/assets//app.js":   files["assets/app.js"],
+		"//assets//app.js":  files["assets/app.js"],
+		"/assets/./app.js":  files["assets/app.js"],
+		"/./assets/app.js?": files["assets/app.js"],
+	} {
+		wr := serve(h, http.MethodGet, target)
+		if wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %d with %q", target, wr.Code, wr.Body.String(), http.StatusOK, want)
+		}
+	}
+
+	h = newTestHandler(t, files, WithCanonicalRedirect())
+	for target, want := range map[string]string{
+		"//index.html":          "/index.html",
+		"/assets//app.js":       "/assets/app.js",
+		"//assets//app.js":      "/assets/app.js",
+		"/assets/./app.js":      "/assets/app.js",
+		"/assets//app.js?v=1.2": "/assets/app.js?v=1.2",
+	} {
+		wr := serve(h, http.MethodGet, target)
+		if wr.Code != http.StatusMovedPermanently || wr.Header().Get("Location") != want {
+			t.Errorf("%s: got status %d to %q, want %d to %q", target, wr.Code, wr.Header().Get("Location"), http.StatusMovedPermanently, want)
+		}
+	}
+
+	// canonical paths, and those of no file (which get the fallback as
+	// usual), aren't redirected - in particular not off-site
+	for _, target := range []string{"/assets/app.js", "/", "//evil.example/", "/some//route"} {
+		if wr := serve(h, http.MethodGet, target, "Accept", "text/html"); wr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d to %q, want %d", target, wr.Code, wr.Header().Get("Location"), http.StatusOK)
+		}
+	}
+}
+
+// a [fs.FS] that fails to open the files named in failing once broken is set
+type brokenFS struct {
+	fs.FS
+	failing []string
+	broken  atomic.Bool
+}
+
+func (bfs *brokenFS) Open(name string) (fs.File, error) {
+	if bfs.broken.Load() && slices.Contains(bfs.failing, name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("disk on fire")}
+	}
+	return bfs.FS.Open(name)
+}
+
+func TestServerErrorPage(t *testing.T) {
+	page := "<html>something went wrong</html>" + compressible
+	fsys := &brokenFS{
+		FS: fstest.MapFS{
+			"index.html":   {Data: []byte("<html></html>")},
+			"500.html":     {Data: []byte(page)},
+			"video.mp4":    {Data: []byte(incompressible(64 << 10))},
+			"big-500.html": {Data: []byte(page + incompressible(64<<10))},
+		},
+		failing: []string{"video.mp4"},
+	}
+
+	// files too large to cache are read on each request, so can fail then
+	h, err := NewHandlerFS(fsys, WithLogger(discardLogger), WithMaxInMemoryBytes(32<<10), WithServerErrorPage("/500.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.broken.Store(true)
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		wr := serve(h, http.MethodGet, "/video.mp4", "Accept-Encoding", acceptEncoding)
+		if wr.Code != http.StatusInternalServerError {
+			t.Errorf("%q: got status %d, want %d", acceptEncoding, wr.Code, http.StatusInternalServerError)
+		}
+		if got := decode(t, acceptEncoding, wr.Body); got != page {
+			t.Errorf("%q: got %q, want the 500 page", acceptEncoding, got)
+		}
+		// which mustn't be cached or revalidated as if it were the video
+		if got := wr.Header().Get("ETag"); got != "" {
+			t.Errorf("%q: got ETag %q, want none", acceptEncoding, got)
+		}
+	}
+
+	// other errors are unaffected
+	if wr := serve(h, http.MethodGet, "/missing.js", "Accept", "*/*"); wr.Code != http.StatusNotFound || wr.Body.Len() != 0 {
+		t.Errorf("404: got status %d with %q, want a bare %d", wr.Code, wr.Body.String(), http.StatusNotFound)
+	}
+
+	// if the page fails too, there's still a plain 500
+	fsys.broken.Store(false)
+	h, err = NewHandlerFS(fsys, WithLogger(discardLogger), WithMaxInMemoryBytes(32<<10), WithServerErrorPage("/big-500.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.failing = append(fsys.failing, "big-500.html")
+	fsys.broken.Store(true)
+
+	wr := serve(h, http.MethodGet, "/video.mp4")
+	if wr.Code != http.StatusInternalServerError || strings.Contains(wr.Body.String(), "something went wrong") {
+		t.Errorf("failing page: got status %d with %q, want a plain %d", wr.Code, wr.Body.String(), http.StatusInternalServerError)
+	}
+	if got := wr.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("failing page: got Content-Type %q, want text/plain", got)
+	}
+	if got := wr.Header().Get("ETag"); got != "" {
+		t.Errorf("failing page: got ETag %q, want none", got)
+	}
+
+	fsys.broken.Store(false)
+	_, err = NewHandlerFS(fsys, WithLogger(discardLogger), WithServerErrorPage("/missing.html"))
+	if err == nil || !strings.Contains(err.Error(), "/missing.html") {
+		t.Errorf("got error %v, want one naming the missing /missing.html", err)
+	}
+}
+
+func TestDefaultRobotsAndFavicon(t *testing.T) {
+	const robots = "User-agent: *\nDisallow:\n"
+	icon := []byte("\x00\x00\x01\x00\x01\x00")
+	files := map[string]string{"index.html": "<html></html>"}
+
+	h := newTestHandler(t, files, WithDefaultRobots(robots), WithDefaultFavicon(icon), WithCacheControl(time.Hour))
+	for _, tt := range []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"/robots.txt", "text/plain; charset=utf-8", robots},
+		{"/favicon.ico", "image/vnd.microsoft.icon", string(icon)},
+	} {
+		// requested like a page, so it would otherwise get the index
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != http.StatusOK || wr.Body.String() != tt.body {
+			t.Errorf("%s: got status %d with %q, want %d with %q", tt.path, wr.Code, wr.Body.String(), http.StatusOK, tt.body)
+		}
+		if got := wr.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.contentType)
+		}
+		if got := wr.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("%s: got Cache-Control %q, want it cached like any other file", tt.path, got)
+		}
+		if wr.Header().Get("ETag") == "" {
+			t.Errorf("%s: got no ETag", tt.path)
+		}
+	}
+
+	// a real file takes precedence
+	existing := map[string]string{
+		"index.html":  "<html></html>",
+		"robots.txt":  "User-agent: *\nDisallow: /admin\n",
+		"favicon.ico": "\x00\x00\x01\x00real",
+	}
+	h = newTestHandler(t, existing, WithDefaultRobots(robots), WithDefaultFavicon(icon))
+	for _, urlpath := range []string{"/robots.txt", "/favicon.ico"} {
+		if wr := serve(h, http.MethodGet, urlpath); wr.Body.String() != existing[urlpath[1:]] {
+			t.Errorf("%s: got %q, want the real file", urlpath, wr.Body.String())
+		}
+	}
+
+	// without them, there's no such route
+	h = newTestHandler(t, files)
+	for _, urlpath := range []string{"/robots.txt", "/favicon.ico"} {
+		if wr := serve(h, http.MethodGet, urlpath, "Accept", "*/*"); wr.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d by default, want %d", urlpath, wr.Code, http.StatusNotFound)
+		}
+	}
+}
+
+type nonceKey struct{}
+
+func TestHTMLTransform(t *testing.T) {
+	index := `<html><head><meta http-equiv="Content-Security-Policy" content="script-src 'nonce-NONCE'"><script nonce="NONCE"></script></head>` + compressible + `</html>`
+	files := map[string]string{
+		"index.html": index,
+		"app.js":     compressible,
+	}
+
+	// e.g. a middleware generates a nonce per request, and passes it along
+	transform := func(r *http.Request, html []byte) []byte {
+		nonce, _ := r.Context().Value(nonceKey{}).(string)
+		return bytes.ReplaceAll(html, []byte("NONCE"), []byte(nonce))
+	}
+	h := newTestHandler(t, files, WithHTMLTransform(transform))
+
+	withNonce := func(target, nonce string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		wr := httptest.NewRecorder()
+		h.ServeHTTP(wr, r)
+		return wr
+	}
+
+	etags := make(map[string]bool)
+	for _, tt := range []struct {
+		target string
+		nonce  string
+	}{
+		{"/", "r4nd0m"},
+		{"/index.html", "0th3r"},
+		{"/some/route", "f4llb4ck"},
+	} {
+		// transformed content can't be served precompressed
+		wr := withNonce(tt.target, tt.nonce, "Accept", "text/html", "Accept-Encoding", "gzip, br")
+		want := strings.ReplaceAll(index, "NONCE", tt.nonce)
+		if wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %d with the nonce injected", tt.target, wr.Code, wr.Body.String(), http.StatusOK)
+		}
+		if !strings.Contains(wr.Body.String(), `content="script-src 'nonce-`+tt.nonce+`'"`) {
+			t.Errorf("%s: nonce %s isn't in the CSP", tt.target, tt.nonce)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: got Content-Encoding %q, want none", tt.target, got)
+		}
+		if got, want := wr.Header().Get("Content-Length"), strconv.Itoa(len(want)); got != want {
+			t.Errorf("%s: got Content-Length %s, want %s", tt.target, got, want)
+		}
+
+		etag := wr.Header().Get("ETag")
+		if etags[etag] {
+			t.Errorf("%s: got the ETag of another nonce's response", tt.target)
+		}
+		etags[etag] = true
+		if wr := withNonce(tt.target, tt.nonce, "Accept", "text/html", "If-None-Match", etag); wr.Code != http.StatusNotModified {
+			t.Errorf("%s: got status %d revalidating, want %d", tt.target, wr.Code, http.StatusNotModified)
+		}
+	}
+
+	// other content is served as usual, and the cache is left as it was
+	if wr := serve(h, http.MethodGet, "/app.js", "Accept-Encoding", "gzip"); wr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("app.js: got Content-Encoding %q, want gzip", wr.Header().Get("Content-Encoding"))
+	}
+	if got := string(h.entries()["/index.html"].identity); got != index {
+		t.Error("the cached index was modified")
+	}
+}
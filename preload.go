@@ -0,0 +1,56 @@
+package spa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// returns the Link header values preloading c's preload paths from cache
+func preloadLinks(cache map[string]cacheEntry, c *config) ([]string, error) {
+	links := make([]string, 0, len(c.preload))
+	for _, urlpath := range c.preload {
+		entry, ok := cache[c.cacheKey(urlpath)]
+		if !ok {
+			return nil, fmt.Errorf("spa: preloaded file %s not found", urlpath)
+		}
+
+		as, crossOrigin := preloadDestination(entry.contentType)
+		if as == "" {
+			return nil, fmt.Errorf("spa: can't preload %s of type %s", urlpath, entry.contentType)
+		}
+
+		link := "<" + c.pathPrefix + urlpath + ">; rel=preload; as=" + as
+		if crossOrigin {
+			// fonts and fetches are requested in CORS mode - without this,
+			// the preloaded response wouldn't be used
+			link += "; crossorigin"
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// returns the destination (the as attribute) a file of contentType is preloaded as
+// ("" if it can't be), and whether it is requested in CORS mode
+func preloadDestination(contentType string) (as string, crossOrigin bool) {
+	mediaType := mediaTypeOf(contentType)
+	switch {
+	case mediaType == "text/javascript" || mediaType == "application/javascript":
+		return "script", false
+	case mediaType == "text/css":
+		return "style", false
+	case mediaType == "application/json" || mediaType == "application/manifest+json":
+		return "fetch", true
+	case strings.HasPrefix(mediaType, "font/"):
+		return "font", true
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image", false
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "audio", false
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video", false
+	}
+
+	return "", false
+}
@@ -0,0 +1,48 @@
+package spa
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPreload(t *testing.T) {
+	files := map[string]string{
+		"index.html":        "<html></html>",
+		"assets/app.js":     "console.log(1)",
+		"assets/app.css":    "body{}",
+		"fonts/inter.woff2": "wOF2",
+		"data.bin":          "\x00\x01",
+	}
+	h := newTestHandler(t, files, WithPreload("/assets/app.js", "assets/app.css"), WithPreload("/fonts/inter.woff2"))
+
+	want := []string{
+		"</assets/app.js>; rel=preload; as=script",
+		"</assets/app.css>; rel=preload; as=style",
+		"</fonts/inter.woff2>; rel=preload; as=font; crossorigin",
+	}
+	for _, target := range []string{"/", "/index.html", "/some/route"} {
+		wr := serve(h, http.MethodGet, target, "Accept", "text/html", "Accept-Encoding", "gzip")
+		if got := wr.Header().Values("Link"); !slices.Equal(got, want) {
+			t.Errorf("%s: got Link %q, want %q", target, got, want)
+		}
+	}
+
+	// only on the index
+	if got := serve(h, http.MethodGet, "/assets/app.js").Header().Values("Link"); len(got) != 0 {
+		t.Errorf("asset: got Link %q, want none", got)
+	}
+
+	// under a prefix, the links point at where the files are served
+	h = newTestHandler(t, files, WithPathPrefix("/app"), WithPreload("/assets/app.js"))
+	if got := serve(h, http.MethodGet, "/app/").Header().Get("Link"); got != "</app/assets/app.js>; rel=preload; as=script" {
+		t.Errorf("prefixed: got Link %q", got)
+	}
+
+	dir := writeTree(t, files)
+	for _, urlpath := range []string{"/missing.js", "/data.bin"} {
+		if _, err := NewHandlerWithOptions(dir, WithLogger(discardLogger), WithPreload(urlpath)); err == nil {
+			t.Errorf("%s: got no error", urlpath)
+		}
+	}
+}
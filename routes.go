@@ -0,0 +1,189 @@
+package spa
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+// RouteInfo describes a single file served by a [Handler]
+type RouteInfo struct {
+	// path as seen in a request's URL.Path (e.g. /index.html)
+	URLPath string
+	// value of the Content-Type header
+	ContentType string
+	// size (in bytes) of the uncompressed content
+	IdentitySize int
+	// size (in bytes) of the gzipped content (-1 if it isn't served gzipped)
+	CompressedSize int
+	// size (in bytes) of the brotli content (-1 if it isn't served brotli)
+	BrotliSize int
+	// true if the content is served compressed to clients that accept it
+	Compressed bool
+}
+
+// Routes returns every file served by h, sorted by URLPath
+func (h *Handler) Routes() []RouteInfo {
+	cache := h.entries()
+	ret := make([]RouteInfo, 0, len(cache))
+	for _, entry := range cache {
+		ret = append(ret, entry.routeInfo())
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].URLPath < ret[j].URLPath
+	})
+
+	return ret
+}
+
+// returns the public view of ce
+func (ce cacheEntry) routeInfo() RouteInfo {
+	return RouteInfo{
+		URLPath:        ce.urlpath,
+		ContentType:    ce.contentType,
+		IdentitySize:   ce.identitySize,
+		CompressedSize: ce.compressedSize,
+		BrotliSize:     ce.brotliSize,
+		Compressed:     ce.shouldServeCompressed,
+	}
+}
+
+// Lookup returns information about the file a request for urlpath would be
+// served (reporting false if there is none - e.g. for unknown routes, which
+// would get the SPA fallback). urlpath is cleaned, and any [WithPathPrefix]
+// trimmed from it, just like the handler does for requests.
+func (h *Handler) Lookup(urlpath string) (RouteInfo, bool) {
+	if !isValidRequestPath(urlpath) {
+		return RouteInfo{}, false
+	}
+
+	p := cleanRequestPath(urlpath)
+	if h.pathPrefix != "" {
+		var ok bool
+		p, ok = trimPathPrefix(p, h.pathPrefix)
+		if !ok {
+			return RouteInfo{}, false
+		}
+	}
+
+	entry, _, ok := h.lookupEntry(h.entries(), p)
+	if !ok {
+		return RouteInfo{}, false
+	}
+
+	return entry.routeInfo(), true
+}
+
+// HandlerStats summarizes the memory cost of a [Handler]'s cache
+type HandlerStats struct {
+	// number of files served
+	Entries int
+	// number of files served compressed to clients that accept it
+	CompressedEntries int
+	// number of files read from the source on each request (see [WithMaxInMemoryBytes])
+	StreamedEntries int
+	// number of files not read yet, as they haven't been requested (see
+	// [NewLazyHandler]) - which aren't counted in any of the below
+	LazyEntries int
+	// number of files whose content is identical to another file's,
+	// and so share its memory
+	DuplicateEntries int
+	// total size (in bytes) of uncompressed content held in memory
+	IdentityBytes int64
+	// total size (in bytes) of compressed (gzip and brotli) content held in memory
+	CompressedBytes int64
+}
+
+// Stats reports the number of files h serves and the bytes it holds to do so
+func (h *Handler) Stats() HandlerStats {
+	var ret HandlerStats
+
+	// identical content is shared, so it is only counted once - compressed
+	// variants are keyed by encoding as well, as entries with different
+	// content types may not serve the same ones
+	seen := make(map[string]bool)
+	for _, entry := range h.entries() {
+		ret.Entries++
+
+		if entry.streamed {
+			ret.StreamedEntries++
+			continue
+		}
+
+		// nothing is known of their content yet (not even its etag)
+		if entry.lazy != nil {
+			ret.LazyEntries++
+			continue
+		}
+
+		if entry.shouldServeCompressed {
+			ret.CompressedEntries++
+		}
+
+		if seen[entry.etag] {
+			ret.DuplicateEntries++
+		} else {
+			seen[entry.etag] = true
+			ret.IdentityBytes += int64(entry.identitySize)
+		}
+
+		if entry.gzipHandler != nil && !seen[entry.etag+encodingGzip] {
+			seen[entry.etag+encodingGzip] = true
+			ret.CompressedBytes += int64(entry.compressedSize)
+		}
+		if entry.brotliHandler != nil && !seen[entry.etag+encodingBrotli] {
+			seen[entry.etag+encodingBrotli] = true
+			ret.CompressedBytes += int64(entry.brotliSize)
+		}
+		for encoding, v := range entry.saveDataVariants {
+			if !seen[entry.etag+encoding] {
+				seen[entry.etag+encoding] = true
+				ret.CompressedBytes += int64(v.size)
+			}
+		}
+	}
+
+	return ret
+}
+
+// logs a summary of h's cache (built in took), for operators to check a
+// deploy at a glance
+func (h *Handler) logSummary(took time.Duration) {
+	stats := h.Stats()
+
+	// how well the files served compressed compress, by their best encoding
+	var identity, compressed int64
+	for _, entry := range h.entries() {
+		sizes := []int{entry.identitySize}
+		if entry.gzipHandler != nil {
+			sizes = append(sizes, entry.compressedSize)
+		}
+		if entry.brotliHandler != nil {
+			sizes = append(sizes, entry.brotliSize)
+		}
+
+		if len(sizes) > 1 {
+			identity += int64(entry.identitySize)
+			compressed += int64(slices.Min(sizes))
+		}
+	}
+
+	ratio := 1.0
+	if identity > 0 {
+		ratio = float64(compressed) / float64(identity)
+	}
+
+	h.logger.LogAttrs(context.Background(), slog.LevelInfo, "spa: handler ready",
+		slog.Int("files", stats.Entries),
+		slog.Int("compressed_files", stats.CompressedEntries),
+		slog.Int("streamed_files", stats.StreamedEntries),
+		slog.Int("lazy_files", stats.LazyEntries),
+		slog.Int64("bytes", stats.IdentityBytes),
+		slog.Int64("compressed_bytes", stats.CompressedBytes),
+		slog.Float64("compression_ratio", ratio),
+		slog.Duration("duration", took),
+	)
+}
@@ -0,0 +1,201 @@
+package spa
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":          compressible,
+		"app.js":              "console.log(1)",
+		"assets/logo.png":     incompressible(100),
+		"assets/css/site.css": "body{}",
+		".env":                "SECRET=1",
+	})
+
+	var got []string
+	for _, route := range h.Routes() {
+		got = append(got, route.URLPath)
+	}
+
+	want := []string{"/app.js", "/assets/css/site.css", "/assets/logo.png", "/index.html"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got routes %q, want %q", got, want)
+	}
+
+	for _, route := range h.Routes() {
+		switch route.URLPath {
+		case "/index.html":
+			if !route.Compressed || route.CompressedSize <= 0 || route.CompressedSize >= route.IdentitySize {
+				t.Errorf("%s: got %+v, want it served compressed", route.URLPath, route)
+			}
+			if route.ContentType != "text/html; charset=utf-8" {
+				t.Errorf("%s: got content type %q", route.URLPath, route.ContentType)
+			}
+		case "/assets/logo.png":
+			if route.Compressed || route.CompressedSize != -1 || route.IdentitySize != 100 {
+				t.Errorf("%s: got %+v, want 100 bytes served uncompressed", route.URLPath, route)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	files := map[string]string{
+		"index.html":      compressible,
+		"app.js":          "console.log(1)",
+		"assets/logo.png": incompressible(100),
+		"docs/guide.txt":  compressible + "guide",
+	}
+	h := newTestHandler(t, files)
+
+	var identity, compressed int64
+	for _, content := range files {
+		identity += int64(len(content))
+	}
+	for _, route := range h.Routes() {
+		compressed += int64(max(route.CompressedSize, 0) + max(route.BrotliSize, 0))
+	}
+
+	want := HandlerStats{
+		Entries:           4,
+		CompressedEntries: 2,
+		IdentityBytes:     identity,
+		CompressedBytes:   compressed,
+	}
+	if got := h.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	files := map[string]string{
+		"index.html":      compressible,
+		"docs/guide.txt":  prose(8 << 10),
+		"app.js":          "console.log(1)",
+		"assets/logo.png": incompressible(100),
+		"video.mp4":       incompressible(64 << 10),
+	}
+	rec := &recordingHandler{}
+	h := newTestHandler(t, files, WithLogger(slog.New(rec)), WithMaxInMemoryBytes(32<<10))
+
+	record, attrs, ok := rec.find("spa: handler ready")
+	if !ok {
+		t.Fatal("got no summary")
+	}
+	if record.Level != slog.LevelInfo {
+		t.Errorf("logged at %v, want %v", record.Level, slog.LevelInfo)
+	}
+
+	// by the smallest variant of each file served compressed
+	var identity, smallest int64
+	for _, urlpath := range []string{"/index.html", "/docs/guide.txt"} {
+		entry := h.entries()[urlpath]
+		identity += int64(entry.identitySize)
+		smallest += int64(min(entry.compressedSize, entry.brotliSize))
+	}
+	ratio := float64(smallest) / float64(identity)
+
+	var compressed int64
+	for _, route := range h.Routes() {
+		compressed += int64(max(route.CompressedSize, 0) + max(route.BrotliSize, 0))
+	}
+
+	for key, want := range map[string]slog.Value{
+		"files":             slog.IntValue(5),
+		"compressed_files":  slog.IntValue(2),
+		"streamed_files":    slog.IntValue(1),
+		"lazy_files":        slog.IntValue(0),
+		"bytes":             slog.Int64Value(int64(len(files["index.html"]) + len(files["docs/guide.txt"]) + len(files["app.js"]) + len(files["assets/logo.png"]))),
+		"compressed_bytes":  slog.Int64Value(compressed),
+		"compression_ratio": slog.Float64Value(ratio),
+	} {
+		if got, ok := attrs[key]; !ok || !got.Equal(want) {
+			t.Errorf("got %s %v, want %v", key, got, want)
+		}
+	}
+	if ratio <= 0 || ratio >= 0.5 {
+		t.Errorf("got compression ratio %v for compressible files", ratio)
+	}
+	if d := attrs["duration"]; d.Kind() != slog.KindDuration || d.Duration() <= 0 {
+		t.Errorf("got duration %v, want a positive duration", d)
+	}
+
+	// files not read yet are reported as such
+	rec = &recordingHandler{}
+	if _, err := NewLazyHandler(writeTree(t, files), WithLogger(slog.New(rec))); err != nil {
+		t.Fatal(err)
+	}
+	_, attrs, _ = rec.find("spa: handler ready")
+	if got := attrs["lazy_files"]; !got.Equal(slog.IntValue(5)) || !attrs["bytes"].Equal(slog.Int64Value(0)) {
+		t.Errorf("lazy: got %v lazy files with %v bytes, want 5 with 0", got, attrs["bytes"])
+	}
+}
+
+func TestDuplicatesShareContent(t *testing.T) {
+	files := map[string]string{
+		"index.html":         "<html></html>",
+		"favicon.txt":        compressible,
+		"assets/favicon.txt": compressible,
+	}
+	h := newTestHandler(t, files)
+
+	for _, urlpath := range []string{"/favicon.txt", "/assets/favicon.txt"} {
+		if wr := serve(h, http.MethodGet, urlpath); wr.Body.String() != compressible {
+			t.Errorf("%s: got %d bytes, want the file", urlpath, wr.Body.Len())
+		}
+	}
+
+	a, b := h.entries()["/favicon.txt"], h.entries()["/assets/favicon.txt"]
+	if &a.identity[0] != &b.identity[0] {
+		t.Error("identical files are held in separate buffers")
+	}
+
+	stats := h.Stats()
+	if stats.DuplicateEntries != 1 {
+		t.Errorf("got %d duplicate entries, want 1", stats.DuplicateEntries)
+	}
+	if want := int64(len(files["index.html"]) + len(compressible)); stats.IdentityBytes != want {
+		t.Errorf("got %d identity bytes, want %d (counting the duplicate once)", stats.IdentityBytes, want)
+	}
+	if want := int64(a.compressedSize + a.brotliSize); stats.CompressedBytes != want {
+		t.Errorf("got %d compressed bytes, want %d (counting the duplicate once)", stats.CompressedBytes, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":      compressible,
+		"assets/logo.png": incompressible(100),
+		"docs/index.html": "<html>docs</html>",
+	}, WithPathPrefix("/app"))
+
+	info, ok := h.Lookup("/app//./index.html")
+	if !ok {
+		t.Fatal("/index.html: got a miss")
+	}
+	entry := h.entries()["/index.html"]
+	if info.URLPath != "/index.html" || info.IdentitySize != len(compressible) || !info.Compressed ||
+		info.CompressedSize != entry.compressedSize || info.BrotliSize != entry.brotliSize || info.CompressedSize >= info.IdentitySize {
+		t.Errorf("/index.html: got %+v", info)
+	}
+
+	info, ok = h.Lookup("/app/assets/logo.png")
+	if !ok || info.Compressed || info.CompressedSize != -1 || info.IdentitySize != 100 || info.ContentType != "image/png" {
+		t.Errorf("/assets/logo.png: got %+v (%v)", info, ok)
+	}
+
+	// directory indexes, as the handler would serve them
+	if info, ok := h.Lookup("/app/docs/"); !ok || info.URLPath != "/docs/index.html" {
+		t.Errorf("/docs/: got %+v (%v), want /docs/index.html", info, ok)
+	}
+
+	for _, urlpath := range []string{"/app/missing.js", "/app/some/route", "/index.html", "/app/../index.html", "/app/a\x00.js"} {
+		if info, ok := h.Lookup(urlpath); ok {
+			t.Errorf("%s: got %+v, want a miss", urlpath, info)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package spa
+
+import (
+	"path"
+	"strings"
+)
+
+// extensions of precompressed sidecar files and the encodings of their content
+var sidecarEncodings = map[string]string{
+	".gz": encodingGzip,
+	".br": encodingBrotli,
+}
+
+// attaches the precompressed sidecars among entries (e.g. app.js.gz next to
+// app.js) to their base files as compressed variants, and returns the
+// entries left once the sidecars themselves are removed.
+//
+// Sidecars without a base file are skipped with a warning.
+func attachSidecars(entries []cacheEntry, c *config) []cacheEntry {
+	positions := make(map[string]int, len(entries))
+	for i, ce := range entries {
+		positions[ce.urlpath] = i
+	}
+
+	ret := make([]cacheEntry, 0, len(entries))
+	for _, sidecar := range entries {
+		encoding, ok := sidecarEncodings[path.Ext(sidecar.urlpath)]
+		if !ok {
+			continue
+		}
+
+		basepath := strings.TrimSuffix(sidecar.urlpath, path.Ext(sidecar.urlpath))
+		i, ok := positions[basepath]
+		if !ok {
+			c.logger.Warn("spa: skipping precompressed file without a base file", "path", sidecar.urlpath)
+			continue
+		}
+
+		if sidecar.streamed {
+			c.logger.Warn("spa: skipping precompressed file too large to hold in memory", "path", sidecar.urlpath)
+			continue
+		}
+
+		// the handlers capture the base entry, so it must be updated in place
+		base := &entries[i]
+		switch encoding {
+		case encodingGzip:
+			base.compressedSize = sidecar.identitySize
+			base.gzipHandler = encodedHandler(base, encoding, sidecar.identity, c.logger)
+		case encodingBrotli:
+			base.brotliSize = sidecar.identitySize
+			base.brotliHandler = encodedHandler(base, encoding, sidecar.identity, c.logger)
+		}
+		base.shouldServeCompressed = true
+
+		c.logger.Debug("spa: using precompressed file", "path", basepath, "file", sidecar.urlpath, "encoding", encoding)
+	}
+
+	for _, ce := range entries {
+		if _, ok := sidecarEncodings[path.Ext(ce.urlpath)]; ok {
+			continue
+		}
+
+		ret = append(ret, ce)
+	}
+
+	return ret
+}
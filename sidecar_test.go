@@ -0,0 +1,62 @@
+package spa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+// returns s gzipped at level
+func gzipped(t *testing.T, s string, level int) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestPrecompressed(t *testing.T) {
+	// unlike what the handler would produce, so it's clear which is served
+	sidecar := gzipped(t, compressible, gzip.HuffmanOnly)
+
+	rec := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{
+		"index.html":   "<html></html>",
+		"app.js":       compressible,
+		"app.js.gz":    sidecar,
+		"orphan.js.gz": gzipped(t, "orphan", gzip.BestSpeed),
+	}, WithPrecompressed(), WithLogger(slog.New(rec)))
+
+	wr := serve(h, http.MethodGet, "/app.js", "Accept-Encoding", "gzip")
+	if got := wr.Header().Get("Content-Encoding"); got != "gzip" || wr.Body.String() != sidecar {
+		t.Errorf("gzip: got Content-Encoding %q with %d bytes, want the %d byte sidecar", got, wr.Body.Len(), len(sidecar))
+	}
+
+	wr = serve(h, http.MethodGet, "/app.js")
+	if got := wr.Header().Get("Content-Encoding"); got != "" || wr.Body.String() != compressible {
+		t.Errorf("identity: got Content-Encoding %q with %d bytes, want the base file", got, wr.Body.Len())
+	}
+
+	for _, urlpath := range []string{"/app.js.gz", "/orphan.js.gz", "/orphan.js"} {
+		if _, ok := h.entries()[urlpath]; ok {
+			t.Errorf("%s is served as a route of its own", urlpath)
+		}
+	}
+
+	_, attrs, ok := rec.find("spa: skipping precompressed file without a base file")
+	if !ok || attrs["path"].String() != "/orphan.js.gz" {
+		t.Errorf("got no warning for /orphan.js.gz")
+	}
+}
@@ -2,79 +2,946 @@
 package spa
 
 import (
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/andybalholm/brotli"
 )
 
-const (
-	defaultWebpath    = "/index.html"
-	tcpPacketDataSize = 1460
-)
+const (
+	defaultWebpath    = "/index.html"
+	wellKnownDir      = ".well-known"
+	tcpPacketDataSize = 1460
+	// a moderate brotli level - its highest are many times slower for little gain
+	defaultBrotliLevel = 5
+
+	// value of the Allow header - this is a read-only handler
+	allowedMethods = "GET, HEAD"
+
+	// content type of content whose type is unknown
+	defaultContentType = "application/octet-stream"
+)
+
+// NewHandler creates a new [Handler] that serves out of dir.
+func NewHandler(dir string) (*Handler, error) {
+	return NewHandlerWithOptions(dir)
+}
+
+// NewHandlerWithOptions creates a new [Handler] that serves out of dir,
+// configured by opts.
+func NewHandlerWithOptions(dir string, opts ...Option) (*Handler, error) {
+	return NewHandlerContext(context.Background(), dir, opts...)
+}
+
+// NewHandlerContext is like [NewHandlerWithOptions], but gives up building the
+// handler - returning ctx's error - once ctx is done. Scanning (and compressing)
+// a large tree can take a while, e.g. during a shutdown that should be prompt.
+//
+// ctx only bounds building the handler; it is not used once this returns.
+func NewHandlerContext(ctx context.Context, dir string, opts ...Option) (*Handler, error) {
+	return newHandler(ctx, []source{{fsys: os.DirFS(dir), dir: dir}}, opts)
+}
+
+// NewHandlerMulti creates a new [Handler] that serves the merged contents
+// of dirs, configured by opts.
+//
+// When the same path exists in more than one directory, the file in the later
+// directory wins (or the handler fails to build, see [WithCollisionError]).
+// The index only has to exist in one of them.
+func NewHandlerMulti(dirs []string, opts ...Option) (*Handler, error) {
+	sources := make([]source, 0, len(dirs))
+	for _, dir := range dirs {
+		sources = append(sources, source{fsys: os.DirFS(dir), dir: dir})
+	}
+
+	return newHandler(context.Background(), sources, opts)
+}
+
+// NewHandlerFS creates a new [Handler] that serves out of fsys,
+// configured by opts.
+//
+// This allows serving content compiled into the binary with an [embed.FS].
+// Paths within fsys are always slash-separated, regardless of the OS.
+func NewHandlerFS(fsys fs.FS, opts ...Option) (*Handler, error) {
+	return newHandler(context.Background(), []source{{fsys: fsys}}, opts)
+}
+
+// NewHandlerLayeredFS creates a new [Handler] that serves the merged contents
+// of layers, configured by opts.
+//
+// This is [NewHandlerMulti] for [fs.FS]s - e.g. an [embed.FS] of defaults,
+// overlaid with per-tenant customizations. A file in a later layer shadows the
+// same path in an earlier one (or the handler fails to build, see
+// [WithCollisionError]), and the index only has to exist in one of them.
+func NewHandlerLayeredFS(layers []fs.FS, opts ...Option) (*Handler, error) {
+	sources := make([]source, 0, len(layers))
+	for _, fsys := range layers {
+		sources = append(sources, source{fsys: fsys})
+	}
+
+	return newHandler(context.Background(), sources, opts)
+}
+
+// NewHandlerArchive creates a new [Handler] that serves the contents of
+// the zip archive read from r (of size bytes), configured by opts.
+//
+// This allows shipping a site as a single file. Paths within the archive are
+// served as if it were a directory, and r must stay readable while the
+// handler is in use (e.g. if [WithMaxInMemoryBytes] leaves files to be read
+// on each request).
+func NewHandlerArchive(r io.ReaderAt, size int64, opts ...Option) (*Handler, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("spa: failed to read archive: %w", err)
+	}
+
+	return newHandler(context.Background(), []source{{fsys: zr}}, opts)
+}
+
+// a filesystem a handler serves out of
+type source struct {
+	fsys fs.FS
+	// the directory fsys serves on disk ("" if it isn't one)
+	dir string
+}
+
+// creates the handler serving the merged contents of sources,
+// giving up once ctx is done
+func newHandler(ctx context.Context, sources []source, opts []Option) (*Handler, error) {
+	start := time.Now()
+	c := newConfig(opts)
+	c.logger.Debug("spa: initializing handler")
+
+	if c.gzipLevel < gzip.HuffmanOnly || c.gzipLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("spa: invalid gzip level %d", c.gzipLevel)
+	}
+
+	if c.brotliLevel < brotli.BestSpeed || c.brotliLevel > brotli.BestCompression {
+		return nil, fmt.Errorf("spa: invalid brotli level %d", c.brotliLevel)
+	}
+
+	if c.minCompressionRatio < 0 || c.minCompressionRatio > 1 {
+		return nil, fmt.Errorf("spa: invalid minimum compression ratio %v", c.minCompressionRatio)
+	}
+
+	if len(c.compressibleTypes) > 0 && len(c.compressedTypes) > 0 {
+		return nil, errors.New("spa: WithCompressibleTypes and WithCompressedTypes can't be combined")
+	}
+
+	for _, m := range c.mimeTypes {
+		if err := addMimeMapping(m[0], m[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	for urlpath, contentType := range c.contentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("spa: invalid content type %q for %s: %w", contentType, urlpath, err)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, errors.New("spa: no directories to serve")
+	}
+
+	if c.hotCacheBytes > 0 {
+		c.hotCache = newHotCache(c.hotCacheBytes)
+	}
+
+	ret := &Handler{
+		sources:            sources,
+		config:             c,
+		indexPath:          c.indexPath,
+		notFoundPath:       c.notFoundPath,
+		pathPrefix:         c.pathPrefix,
+		trailingSlash:      c.trailingSlash,
+		canonicalRedirect:  c.canonicalRedirect,
+		directoryListing:   c.directoryListing,
+		debugEncodingParam: c.debugEncodingParam,
+		spaFallback:        c.spaFallback,
+		navigationFallback: c.navigationFallback,
+		assetNotFound:      c.assetNotFound,
+		errorHandler:       c.errorHandler,
+		logger:             c.logger,
+		healthPath:         c.healthPath,
+		accessLog:          c.accessLog,
+		accessLogLevel:     c.accessLogLevel,
+	}
+
+	if c.maxConcurrentRequests > 0 {
+		ret.slots = make(chan struct{}, c.maxConcurrentRequests)
+	}
+
+	if c.serverErrorPath != "" {
+		// read by the handlers at request time, so set before they are built
+		c.errorHandler = ret.serverErrorPageHandler(c.errorHandler)
+		ret.errorHandler = c.errorHandler
+	}
+
+	// read by the streamed files' handlers, so set before they are built
+	c.evict = ret.evict
+
+	err := ret.reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.watchCtx != nil {
+		dirs := make([]string, 0, len(sources))
+		for _, src := range sources {
+			if src.dir == "" {
+				return nil, errors.New("spa: WithWatch requires a handler serving a directory")
+			}
+			dirs = append(dirs, src.dir)
+		}
+
+		err = ret.watch(c.watchCtx, dirs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret.logSummary(time.Since(start))
+	return ret, nil
+}
+
+// scans sources and returns the resulting cache, keyed by urlpath.
+// Returns ctx's error if it is done before the cache is built.
+func buildCache(ctx context.Context, sources []source, c *config) (map[string]cacheEntry, error) {
+	var entries []cacheEntry
+	positions := make(map[string]int)
+	contents := make(map[string][]byte)
+
+	for layer, src := range sources {
+		root := scanRoot{fsys: src.fsys}
+		if src.dir != "" {
+			realDir, err := filepath.Abs(src.dir)
+			if err == nil {
+				realDir, err = filepath.EvalSymlinks(realDir)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("spa: failed to read directory %s: %w", src.dir, err)
+			}
+
+			root.realDir = realDir
+			root.chain = []string{realDir}
+		}
+
+		srcEntries, err := appendDirEntries(ctx, nil, root, c, contents)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.precompressed && !c.lazy {
+			srcEntries = attachSidecars(srcEntries, c)
+		}
+
+		// later sources override earlier ones, in place to keep the order stable
+		for _, entry := range srcEntries {
+			i, ok := positions[entry.urlpath]
+			if !ok {
+				positions[entry.urlpath] = len(entries)
+				entries = append(entries, entry)
+				continue
+			}
+
+			if c.collisionError {
+				return nil, fmt.Errorf("spa: %s exists in more than one directory", entry.urlpath)
+			}
+
+			if src.dir == "" {
+				c.logger.Debug(fmt.Sprintf("spa: %s overridden by layer %d", entry.urlpath, layer))
+			} else {
+				c.logger.Debug(fmt.Sprintf("spa: %s overridden by %s", entry.urlpath, src.dir))
+			}
+			entries[i] = entry
+		}
+	}
+
+	entries = appendDefaultEntries(entries, positions, c)
+
+	if len(entries) == 0 {
+		// rather than complaining about the index, as below
+		dirs := make([]string, 0, len(sources))
+		for _, src := range sources {
+			if src.dir != "" {
+				dirs = append(dirs, src.dir)
+			}
+		}
+		if len(dirs) == 0 {
+			return nil, errors.New("spa: no files to serve found (is the filesystem empty?)")
+		}
+		return nil, fmt.Errorf("spa: no files to serve found in %s (is it empty?)", strings.Join(dirs, ", "))
+	}
+
+	err := compressEntries(ctx, entries, c, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]cacheEntry, len(entries))
+	for _, entry := range entries {
+		if !entry.streamed && entry.lazy == nil {
+			c.logger.Info(fmt.Sprintf("spa: cached file %s (%s) (%d bytes, %d gzipped, %d brotli)", entry.urlpath, entry.contentType, entry.identitySize, entry.compressedSize, entry.brotliSize))
+		}
+
+		key := c.cacheKey(entry.urlpath)
+		if other, ok := cache[key]; ok {
+			c.logger.Warn("spa: paths collide ignoring case, only one is served", "path", entry.urlpath, "other", other.urlpath)
+		}
+		cache[key] = entry
+	}
+
+	if _, ok := cache[c.cacheKey(c.indexPath)]; !ok {
+		return nil, errors.New("spa: root " + c.indexPath + " not found")
+	}
+
+	if len(c.preload) > 0 {
+		links, err := preloadLinks(cache, c)
+		if err != nil {
+			return nil, err
+		}
+
+		index := cache[c.cacheKey(c.indexPath)]
+		index.links = links
+		cache[c.cacheKey(c.indexPath)] = index
+	}
+
+	for urlpath, target := range c.aliases {
+		if _, ok := cache[c.cacheKey(target)]; !ok {
+			return nil, fmt.Errorf("spa: target %s of alias %s not found", target, urlpath)
+		}
+	}
+
+	if c.serverErrorPath != "" {
+		if _, ok := cache[c.cacheKey(c.serverErrorPath)]; !ok {
+			return nil, errors.New("spa: server error page " + c.serverErrorPath + " not found")
+		}
+	}
+
+	if c.notFoundPath != "" {
+		if _, ok := cache[c.cacheKey(c.notFoundPath)]; !ok {
+			return nil, errors.New("spa: not found page " + c.notFoundPath + " not found")
+		}
+	}
+
+	return cache, nil
+}
+
+// Handler is the [http.Handler] that serves a single-page app
+// out of its in-memory cache.
+//
+// It is safe for concurrent use, and its methods can be called while it serves.
+type Handler struct {
+	// sources the cache is built from, in order of precedence (last wins)
+	sources []source
+	config  *config
+
+	// current cache, keyed by urlpath - swapped out wholesale by Reload
+	cache atomic.Pointer[map[string]cacheEntry]
+	// current rules of the sources' _headers files - swapped out along with the cache
+	headerRules atomic.Pointer[[]headerRule]
+	// response served to every request while in maintenance mode (nil if not)
+	maintenance atomic.Pointer[maintenance]
+	// one element for each request being served (nil for no limit)
+	slots chan struct{}
+	// stops watching the sources and waits for the watcher to finish (nil if not watching)
+	stopWatching func()
+	closeOnce    sync.Once
+	// reads of the files of a lazy handler in progress
+	loading loadGroup
+
+	// urlpath of the SPA fallback
+	indexPath string
+	// urlpath of the page served for missing assets ("" for a bare 404)
+	notFoundPath string
+	// request path prefix the handler is mounted under ("" for none)
+	pathPrefix string
+	// how paths differing only by a trailing slash are redirected (0 for not at all)
+	trailingSlash TrailingSlashMode
+	// true to redirect paths that aren't in their cleaned form
+	canonicalRedirect bool
+	// true to list the contents of directories without an index.html
+	directoryListing bool
+	// name of the query parameter forcing an encoding ("" for none)
+	debugEncodingParam string
+	// true to serve the index for unknown routes
+	spaFallback bool
+	// true to only serve the index for unknown routes to navigation requests
+	navigationFallback bool
+	// true to answer requests for unknown paths with a file extension that
+	// aren't navigations with a 404
+	assetNotFound bool
+	// writes error responses (nil for bare status lines)
+	errorHandler errorHandler
+	logger       *slog.Logger
+	// urlpath of the health check ("" for none)
+	healthPath string
+	// true to log every request at accessLogLevel
+	accessLog      bool
+	accessLogLevel slog.Level
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// Reload rescans the handler's source and atomically swaps in the new cache.
+// Requests already in flight finish with the cache they started with.
+//
+// If the rescan fails (e.g. the index has gone missing), the previous cache
+// is kept and the error is returned.
+func (h *Handler) Reload() error {
+	return h.reload(context.Background())
+}
+
+// rescans the handler's source, giving up once ctx is done
+func (h *Handler) reload(ctx context.Context) error {
+	cache, err := buildCache(ctx, h.sources, h.config)
+	if err != nil {
+		return err
+	}
+
+	var rules []headerRule
+	if h.config.headersFile {
+		rules, err = loadHeaderRules(h.sources)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.cache.Store(&cache)
+	h.headerRules.Store(&rules)
+	return nil
+}
+
+// Close stops any background work of the handler (i.e. watching its sources,
+// see [WithWatch]) and waits for it to finish. The handler keeps serving its
+// current content. It is safe to call Close more than once.
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() {
+		if h.stopWatching != nil {
+			h.stopWatching()
+		}
+	})
+
+	return nil
+}
+
+// removes ce (a streamed file found to be gone) from the current cache, so
+// that later requests for it are treated like those for any other missing
+// file - unless the cache has since been replaced with one that doesn't hold ce
+func (h *Handler) evict(ce *cacheEntry) {
+	key := h.config.cacheKey(ce.urlpath)
+	for {
+		current := h.cache.Load()
+		if entry, ok := (*current)[key]; !ok || entry.etag != ce.etag {
+			return
+		}
+
+		next := maps.Clone(*current)
+		delete(next, key)
+		if h.cache.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+// returns the current cache, keyed by urlpath
+func (h *Handler) entries() map[string]cacheEntry {
+	return *h.cache.Load()
+}
+
+// sets the headers c adds to every response - whatever its status, so they
+// are set first - then answers r itself if its method is anything but GET or
+// HEAD. Reports whether r is still to be served.
+func (c *config) startResponse(wr http.ResponseWriter, r *http.Request) bool {
+	if c.nosniff {
+		wr.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	for name, value := range c.headers {
+		wr.Header().Set(name, value)
+	}
+	if c.hsts != "" && isHTTPS(r) {
+		wr.Header().Set("Strict-Transport-Security", c.hsts)
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodOptions:
+		wr.Header().Set("Allow", allowedMethods)
+		wr.WriteHeader(http.StatusNoContent)
+		return false
+	default:
+		wr.Header().Set("Allow", allowedMethods)
+		writeError(wr, r, http.StatusMethodNotAllowed, c.errorHandler)
+		return false
+	}
+}
+
+// ServeHTTP implements [http.Handler]
+func (h *Handler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	if h.accessLog || h.config.onServe != nil {
+		aw := &accessLogWriter{ResponseWriter: wr}
+		defer h.served(r, aw, time.Now())
+		wr = aw
+	}
+
+	if !h.config.startResponse(wr, r) {
+		return
+	}
+
+	originalPath := r.URL.Path
+	if !isValidRequestPath(originalPath) {
+		h.logger.Debug(fmt.Sprintf("spa: rejecting invalid path: %q", originalPath))
+		writeError(wr, r, http.StatusBadRequest, h.errorHandler)
+		return
+	}
+
+	p := cleanRequestPath(r.URL.Path)
+	if h.pathPrefix != "" {
+		var ok bool
+		p, ok = trimPathPrefix(p, h.pathPrefix)
+		if !ok {
+			h.logger.Debug(fmt.Sprintf("spa: request outside of prefix %s: %s", h.pathPrefix, originalPath))
+			writeError(wr, r, http.StatusNotFound, h.errorHandler)
+			return
+		}
+	}
+
+	// checked first - so that a file can't shadow it, and so that the
+	// handler still reports itself as alive during maintenance
+	if h.healthPath != "" && p == h.healthPath {
+		h.serveHealth(wr, r)
+		return
+	}
+
+	if m := h.maintenance.Load(); m != nil {
+		m.serve(wr, r, h.errorHandler)
+		return
+	}
+
+	if h.slots != nil {
+		if !h.acquireSlot(wr, r) {
+			return
+		}
+		defer h.releaseSlot()
+	}
+
+	for _, rule := range *h.headerRules.Load() {
+		if rule.matches(p) {
+			for _, header := range rule.headers {
+				wr.Header().Set(header[0], header[1])
+			}
+		}
+	}
+
+	if h.debugEncodingParam != "" {
+		wr, r = h.forceEncoding(wr, r)
+	}
+
+	cache := h.entries()
+	entry, dirIndex, ok := h.lookupEntry(cache, p)
+	if target, isAlias := h.config.aliases[p]; !ok && isAlias {
+		entry, ok = cache[h.config.cacheKey(target)]
+	}
+
+	if ok && h.trailingSlash != 0 && p != "/" {
+		hasSlash := strings.HasSuffix(r.URL.Path, "/")
+		if (h.trailingSlash == TrailingSlashStrip && hasSlash) || (h.trailingSlash == TrailingSlashAdd && !hasSlash && dirIndex) {
+			h.redirectTrailingSlash(wr, r, p)
+			return
+		}
+	}
+
+	if ok && h.canonicalRedirect && p != "/" {
+		// e.g. //assets//app.js or /assets/./app.js - whichever
+		// trailing slash the request had is kept
+		canonical := p
+		if strings.HasSuffix(r.URL.Path, "/") {
+			canonical += "/"
+		}
+		if h.pathPrefix+canonical != r.URL.Path {
+			h.redirect(wr, r, canonical)
+			return
+		}
+	}
+
+	if !ok && h.directoryListing {
+		if names := listDir(cache, p); names != nil {
+			h.serveListing(wr, r, p, names)
+			return
+		}
+	}
+
+	// a missing asset should surface as a real 404 in the browser console
+	// rather than as the index's HTML - but a client-side route with a dot
+	// in it (e.g. /users/jane.doe) is still navigated to like any other
+	checkAsset := h.assetNotFound && isAssetPath(p)
+	if !ok && h.spaFallback && (h.navigationFallback || checkAsset) {
+		// whether this gets the index depends on the request's headers
+		wr.Header().Add("Vary", "Accept, Sec-Fetch-Mode")
+	}
+
+	fallback := h.spaFallback
+	if fallback && (h.navigationFallback || checkAsset) {
+		fallback = isNavigation(r)
+	}
+
+	if !ok && !fallback {
+		h.logger.Debug(fmt.Sprintf("spa: not found: %s", p))
+		// the page itself may have been evicted, having been deleted since
+		// the scan (see [Handler.evict]) - which leaves a bare 404
+		if page, ok := cache[h.config.cacheKey(h.notFoundPath)]; h.notFoundPath != "" && ok {
+			page, ok = h.loaded(wr, r, page)
+			if ok {
+				servePage(wr, r, h.transformed(r, page), http.StatusNotFound, h.errorHandler)
+			}
+			return
+		}
+
+		writeError(wr, r, http.StatusNotFound, h.errorHandler)
+		return
+	}
+
+	if !ok {
+		// the fallback is served just like a request for the index itself -
+		// compressed, conditional, and with the same headers
+		p = h.indexPath
+		entry, ok = cache[h.config.cacheKey(p)]
+	}
+
+	if !ok {
+		// the constructors guarantee the index exists, so this is a broken state
+		h.logger.Error("spa: fallback index is missing", "path", originalPath, "index", h.indexPath)
+		if h.errorHandler != nil {
+			h.errorHandler(wr, r, http.StatusInternalServerError)
+			return
+		}
+
+		http.Error(wr, "internal server error: the site's index page ("+h.indexPath+") is missing", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Debug(fmt.Sprintf("spa: request for %s (original: %s)", p, originalPath))
+
+	entry, ok = h.loaded(wr, r, entry)
+	if !ok {
+		return
+	}
+
+	h.transformed(r, entry).serve(wr, r, h.errorHandler)
+}
+
+// returns entry with its HTML rewritten for r as configured (see
+// [WithHTMLTransform]), or entry itself if it isn't to be rewritten
+func (h *Handler) transformed(r *http.Request, entry cacheEntry) cacheEntry {
+	if h.config.htmlTransform == nil || entry.streamed || mediaTypeOf(entry.contentType) != "text/html" {
+		return entry
+	}
+
+	// the modification time says nothing about content that varies by request
+	ret := buildCacheEntry(entry.urlpath, entry.contentType, h.config.htmlTransform(r, entry.identity), time.Time{})
+	ret.cacheControl = entry.cacheControl
+	ret.links = entry.links
+	return ret
+}
+
+// Reports whether urlpath (a decoded request path) can be safely mapped into
+// the cache. Paths containing null bytes, backslashes, or ".." segments (even
+// when smuggled in as e.g. ..%2F) are never legitimate requests for a file.
+func isValidRequestPath(urlpath string) bool {
+	if strings.ContainsAny(urlpath, "\x00\\") {
+		return false
+	}
+
+	for _, segment := range strings.Split(urlpath, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// redirects r to p (a cleaned, prefix-less path) with the trailing slash added
+// or removed as configured, preserving the query string
+func (h *Handler) redirectTrailingSlash(wr http.ResponseWriter, r *http.Request, p string) {
+	if h.trailingSlash == TrailingSlashAdd {
+		p += "/"
+	}
+
+	h.redirect(wr, r, p)
+}
+
+// permanently redirects r to the (cleaned) urlpath p within the
+// handler's prefix, keeping r's query string
+func (h *Handler) redirect(wr http.ResponseWriter, r *http.Request, p string) {
+	// built from the cleaned path, so a request like //evil.example/
+	// can't turn into an off-site redirect
+	location := h.pathPrefix + p
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	h.logger.Debug(fmt.Sprintf("spa: redirecting %s to %s", r.URL.Path, location))
+	http.Redirect(wr, r, location, http.StatusMovedPermanently)
+}
+
+// writes an error response for a request that can't be served
+type errorHandler func(wr http.ResponseWriter, r *http.Request, status int)
+
+// writes status with onError, or as a bare status line if it is nil
+func writeError(wr http.ResponseWriter, r *http.Request, status int, onError errorHandler) {
+	if onError != nil {
+		onError(wr, r, status)
+		return
+	}
+
+	wr.WriteHeader(status)
+}
+
+// returns urlpath with prefix (which has no trailing slash) removed.
+// Reports false if urlpath is not prefix or below it.
+func trimPathPrefix(urlpath string, prefix string) (string, bool) {
+	if urlpath == prefix {
+		return "/", true
+	}
+
+	if !strings.HasPrefix(urlpath, prefix+"/") {
+		return "", false
+	}
+
+	return urlpath[len(prefix):], true
+}
+
+// serves the cached page (e.g. a styled 404.html) with status instead of 200 OK
+// (writing any error status of its own with onError)
+func servePage(wr http.ResponseWriter, r *http.Request, page cacheEntry, status int, onError errorHandler) {
+	// the page stands in for the requested resource,
+	// so the page's validators and byte ranges don't apply
+	r = r.Clone(r.Context())
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+		r.Header.Del(h)
+	}
+
+	page.serve(&statusWriter{ResponseWriter: wr, status: status}, r, onError)
+}
 
-// NewHandler creates a new [http.Handler] that serves out of dir
-func NewHandler(dir string) (http.Handler, error) {
-	slog.Debug("spa: initializing handler")
+// marks the context of a request the server error page is being served for
+type serverErrorPageKey struct{}
 
-	cache, err := appendDirEntries(nil, "/", dir)
-	if err != nil {
-		return nil, err
+// returns an errorHandler that serves the server error page for a 500, and
+// writes any other status with onError
+func (h *Handler) serverErrorPageHandler(onError errorHandler) errorHandler {
+	// if even the page fails, there's nothing left to do but say so plainly
+	plain := func(wr http.ResponseWriter, r *http.Request, status int) {
+		// these describe the page, not this response
+		delRepresentationHeaders(wr.Header())
+		http.Error(wr, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+
+	return func(wr http.ResponseWriter, r *http.Request, status int) {
+		// a page too large to cache is read on each request, and reports
+		// failing to be read to this very handler (whatever the status)
+		if r.Context().Value(serverErrorPageKey{}) != nil {
+			plain(wr, r, status)
+			return
+		}
+
+		if status != http.StatusInternalServerError {
+			writeError(wr, r, status, onError)
+			return
+		}
+
+		page, ok := h.entries()[h.config.cacheKey(h.config.serverErrorPath)]
+		if ok {
+			var err error
+			page, err = h.load(page)
+			if err != nil {
+				h.logger.Error("spa: error reading server error page", "path", h.config.serverErrorPath, "err", err)
+				ok = false
+			}
+		}
+		if !ok {
+			http.Error(wr, http.StatusText(status), status)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), serverErrorPageKey{}, true))
+		servePage(wr, r, page, status, plain)
 	}
+}
+
+// removes the headers describing a file served (its validators, caching, and
+// range support) from h, for a response that stands in for the file instead
+func delRepresentationHeaders(h http.Header) {
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Cache-Control")
+	h.Del("Accept-Ranges")
+}
+
+// an [http.ResponseWriter] that replaces a 200 OK status with another status
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
 
-	ret := handler{cache: make(map[string]cacheEntry, len(cache))}
-	for _, entry := range cache {
-		ret.cache[entry.urlpath] = entry
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.wroteHeader {
+		return
 	}
+	sw.wroteHeader = true
 
-	if _, ok := ret.cache[defaultWebpath]; !ok {
-		return nil, errors.New("spa: root " + defaultWebpath + " not found")
+	if code == http.StatusOK {
+		// these describe the page itself, not the response we're standing in for
+		delRepresentationHeaders(sw.Header())
+		code = sw.status
 	}
 
-	return ret, nil
+	sw.ResponseWriter.WriteHeader(code)
 }
 
-type handler struct {
-	cache map[string]cacheEntry
+func (sw *statusWriter) Write(bs []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+
+	return sw.ResponseWriter.Write(bs)
 }
 
-// ServeHTTP implements [http.Handler]
-func (h handler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
-	originalPath := r.URL.Path
+// returns the wrapped writer, for [http.ResponseController]
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
 
-	p := r.URL.Path
-	if !path.IsAbs(p) {
-		p = "/" + p
+// returns urlpath rooted and cleaned (e.g. /a/c for a/b/../c/)
+func cleanRequestPath(urlpath string) string {
+	if !path.IsAbs(urlpath) {
+		urlpath = "/" + urlpath
 	}
 
-	p = path.Clean(p)
+	return path.Clean(urlpath)
+}
 
-	entry, ok := h.cache[p]
-	if !ok {
-		p = "/index.html"
-		entry, ok = h.cache[p]
+// returns the entry in cache serving the (cleaned) urlpath p - either the file
+// at p, or the index.html of the directory at p (reporting true for dirIndex)
+func (h *Handler) lookupEntry(cache map[string]cacheEntry, p string) (entry cacheEntry, dirIndex bool, ok bool) {
+	entry, ok = cache[h.config.cacheKey(p)]
+	if ok {
+		return entry, false, true
 	}
 
-	if !ok {
-		wr.WriteHeader(http.StatusInternalServerError)
-		return
+	// classic static server behavior: /docs/ (or /docs) serves /docs/index.html
+	entry, ok = cache[h.config.cacheKey(path.Join(p, "index.html"))]
+	return entry, ok, ok
+}
+
+// returns r with its Accept-Encoding replaced by the coding named in its
+// debug encoding parameter (e.g. ?enc=gzip), if it names a known one - and
+// wr wrapped so that the response isn't cached
+func (h *Handler) forceEncoding(wr http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	coding := r.URL.Query().Get(h.debugEncodingParam)
+	switch coding {
+	case encodingIdentity, encodingGzip, encodingBrotli:
+	default:
+		return wr, r
 	}
-	slog.Debug(fmt.Sprintf("spa: request for %s (original: %s", p, originalPath))
 
-	if entry.gzipHandler != nil && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		entry.gzipHandler(wr)
-		return
+	h.logger.Debug(fmt.Sprintf("spa: forcing encoding %s for %s", coding, r.URL.Path))
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Accept-Encoding", coding)
+	return &noStoreWriter{ResponseWriter: wr}, r
+}
+
+// an [http.ResponseWriter] that sends Cache-Control: no-store, whatever
+// Cache-Control was set before the header is written
+type noStoreWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (nw *noStoreWriter) WriteHeader(code int) {
+	if !nw.wroteHeader {
+		nw.wroteHeader = true
+		nw.Header().Set("Cache-Control", "no-store")
+	}
+	nw.ResponseWriter.WriteHeader(code)
+}
+
+func (nw *noStoreWriter) Write(bs []byte) (int, error) {
+	if !nw.wroteHeader {
+		nw.WriteHeader(http.StatusOK)
+	}
+	return nw.ResponseWriter.Write(bs)
+}
+
+// returns the wrapped writer, for [http.ResponseController]
+func (nw *noStoreWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
+
+// Reports whether r arrived over HTTPS - directly, or through a proxy
+// that says so with X-Forwarded-Proto
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// Reports whether urlpath looks like a request for a static asset (i.e. it has
+// a file extension) rather than a client-side route that should get the SPA
+// fallback (see [WithAssetNotFound]). E.g. a missing app.js.map must get a 404 -
+// browser devtools choke on the index's HTML in its place.
+func isAssetPath(urlpath string) bool {
+	return path.Ext(urlpath) != ""
+}
+
+// Reports whether r looks like a browser navigating to a page (rather than
+// e.g. a fetch expecting JSON). Requests without an Accept header are given
+// the benefit of the doubt.
+func isNavigation(r *http.Request) bool {
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return mode == "navigate"
+	}
+
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return true
+	}
+
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/html") {
+				return true
+			}
+		}
 	}
 
-	entry.identityHandler(wr)
+	return false
 }
 
 type cacheEntry struct {
@@ -82,166 +949,600 @@ type cacheEntry struct {
 	urlpath string
 	// mime type of this cached entry
 	contentType string
+	// strong entity tag (including quotes) derived from the identity content
+	etag string
+	// modification time of the source file
+	// will be the zero time if the source did not report one (e.g. [embed.FS])
+	modTime time.Time
+	// value of the Cache-Control header ("" for none)
+	cacheControl string
+	// values of the Link headers (e.g. preloading assets)
+	links []string
 
 	// size (in bytes) of content served by identityHandler
 	identitySize int
+	// the uncompressed content
+	// will be nil if streamed is true
+	identity []byte
+	// true if the content is read from the source on each request
+	// rather than held in memory
+	streamed bool
+	// the file yet to be read for the content (nil once it has been, or if
+	// it was up front)
+	lazy *lazyFile
+	// the filesystem (and path within it) the content was read from
+	source fs.FS
+	fpath  string
 	// handler that serves the content uncompressed
-	identityHandler func(wr http.ResponseWriter)
+	identityHandler func(wr http.ResponseWriter, r *http.Request)
 
 	// true if this content should attempt to use a compressed encoding.
 	// note - the caller must still consult the client's Accept-Encoding values
 	shouldServeCompressed bool
 	// size (in bytes) of content served by gzipHandler
-	// will be 0 if shouldServeCompressed is false
+	// will be -1 if gzipHandler is nil
 	compressedSize int
-	// handler that serves the content compressed
-	// will be nil if shouldServeCompressed is false
-	gzipHandler func(wr http.ResponseWriter)
+	// handler that serves the content gzipped
+	// will be nil if gzip does not save enough to be worthwhile
+	gzipHandler func(wr http.ResponseWriter, r *http.Request)
+	// size (in bytes) of content served by brotliHandler
+	// will be -1 if brotliHandler is nil
+	brotliSize int
+	// handler that serves the content brotli compressed
+	// will be nil if brotli does not save enough to be worthwhile
+	brotliHandler func(wr http.ResponseWriter, r *http.Request)
+	// compressed content that saves too little to be worthwhile, but is still
+	// served to clients asking to save data (see requestsSaveData), by encoding
+	saveDataVariants map[string]saveDataVariant
+}
+
+// compressed content only served to clients asking to save data
+type saveDataVariant struct {
+	// size (in bytes) of the content served by handler
+	size    int
+	handler func(wr http.ResponseWriter, r *http.Request)
 }
 
 // Implements [http.Handler]
 func (ce cacheEntry) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
-	if ce.shouldServeCompressed && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		ce.gzipHandler(wr)
+	ce.serve(wr, r, nil)
+}
+
+// serves ce, writing any error status with onError (or bare, if nil)
+func (ce cacheEntry) serve(wr http.ResponseWriter, r *http.Request, onError errorHandler) {
+	if ce.shouldServeCompressed || len(ce.saveDataVariants) > 0 {
+		// the representation depends on Accept-Encoding, so shared caches
+		// must key on it - even when we end up serving identity
+		wr.Header().Add("Vary", "Accept-Encoding")
+	}
+	if len(ce.saveDataVariants) > 0 {
+		wr.Header().Add("Vary", "Save-Data")
+	}
+	saveData := len(ce.saveDataVariants) > 0 && requestsSaveData(r)
+
+	for _, link := range ce.links {
+		wr.Header().Add("Link", link)
+	}
+
+	if ce.cacheControl != "" {
+		wr.Header().Set("Cache-Control", ce.cacheControl)
+	}
+	if !ce.modTime.IsZero() {
+		wr.Header().Set("Last-Modified", ce.modTime.UTC().Format(http.TimeFormat))
+	}
+
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		// HTTP/1.0 intermediaries may not understand Content-Encoding, so
+		// only codings the client explicitly named are used
+		acceptEncoding = withoutWildcard(acceptEncoding)
+	}
+
+	// byte ranges are served from the identity representation only,
+	// as they can't be combined with the precompressed variants. A stale
+	// If-Range gets the full content instead, so is negotiated as usual.
+	encoding, ok := "", false
+	if isRangeRequest(r) && ce.ifRangeMatches(r) {
+		encoding, ok = negotiateEncoding(acceptEncoding, encodingIdentity)
+	}
+
+	if !ok {
+		encoding, ok = negotiateEncoding(acceptEncoding, ce.encodings(saveData)...)
+	}
+
+	if !ok {
+		// e.g. identity;q=0 for content we can't (or won't) compress
+		writeError(wr, r, http.StatusNotAcceptable, onError)
+		return
+	}
+
+	// each encoding is a representation of its own, so gets its own entity
+	// tag - and a conditional request only matches the one it would be served
+	etag := ce.etagFor(encoding)
+	wr.Header().Set("ETag", etag)
+	if ce.notModified(r, etag) {
+		wr.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if encoding == encodingIdentity {
+		ce.identityHandler(wr, r)
+		return
+	}
+
+	ce.handlerFor(encoding, saveData)(wr, r)
+}
+
+// returns the content codings ce can be served with (including those only
+// served to clients asking to save data, if saveData), in order of preference
+func (ce cacheEntry) encodings(saveData bool) []string {
+	// prefer brotli, as it generally compresses text better than gzip -
+	// which also makes it the choice for clients asking to save data
+	ret := make([]string, 0, 3)
+	for _, encoding := range []string{encodingBrotli, encodingGzip} {
+		if ce.handlerFor(encoding, saveData) != nil {
+			ret = append(ret, encoding)
+		}
+	}
+
+	return append(ret, encodingIdentity)
+}
+
+// returns the handler serving ce's content compressed with encoding (falling
+// back to content only served to clients asking to save data, if saveData),
+// or nil if there is none
+func (ce cacheEntry) handlerFor(encoding string, saveData bool) func(wr http.ResponseWriter, r *http.Request) {
+	var ret func(wr http.ResponseWriter, r *http.Request)
+	switch encoding {
+	case encodingBrotli:
+		ret = ce.brotliHandler
+	case encodingGzip:
+		ret = ce.gzipHandler
+	}
+
+	if ret == nil && saveData {
+		ret = ce.saveDataVariants[encoding].handler
+	}
+
+	return ret
+}
+
+// Reports whether r comes from a client asking for the smallest responses
+// (i.e. with Save-Data: on)
+func requestsSaveData(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Save-Data")), "on")
+}
+
+// returns the entity tag of ce's content served with encoding (e.g. "abc-gzip"
+// for gzip, where the identity content's is "abc")
+func (ce cacheEntry) etagFor(encoding string) string {
+	if encoding == encodingIdentity {
+		return ce.etag
+	}
+
+	return strings.TrimSuffix(ce.etag, `"`) + "-" + encoding + `"`
+}
+
+// logs err, which cut short writing the response to r (with args adding
+// context) - at debug level if it's just the client having gone away (e.g. a
+// cancelled download), which is routine and nothing to be done about.
+// The status has been sent by then, so there's no writing an error response.
+func logServeError(logger *slog.Logger, r *http.Request, err error, args ...any) {
+	args = append(args, "err", err)
+	if isClientGone(r, err) {
+		logger.Debug("spa: client went away mid-response", args...)
 		return
 	}
 
-	ce.identityHandler(wr)
+	logger.Error("spa: error serving file", args...)
+}
+
+// Reports whether err (from writing the response to r) is down to the client
+// having disconnected
+func isClientGone(r *http.Request, err error) bool {
+	return r.Context().Err() != nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, http.ErrAbortHandler) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Reports whether r asks for byte ranges of the content
+func isRangeRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Range"), "bytes=")
+}
+
+// Reports whether r's If-Range header (if any) still matches ce, so that its
+// Range can be honored. Per RFC 9110, an entity tag must match strongly, and
+// a date must exactly match the Last-Modified time.
+func (ce cacheEntry) ifRangeMatches(r *http.Request) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == ce.etag
+	}
+
+	t, err := http.ParseTime(ir)
+	if err != nil || ce.modTime.IsZero() {
+		return false
+	}
+
+	return ce.modTime.Truncate(time.Second).Equal(t)
 }
 
-// appends (and returns) cacheEntrys to found at fpath to slice
-func appendDirEntries(slice []cacheEntry, urlpath string, fpath string) ([]cacheEntry, error) {
-	slog.Debug(fmt.Sprintf("spa: reading directory: %s", fpath))
+// Reports whether the If-None-Match header value inm matches etag.
+// Per RFC 9110, If-None-Match uses the weak comparison function.
+func etagMatches(inm string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether r's conditional headers allow a 304 response for the
+// representation with etag. If-Modified-Since is only consulted when
+// If-None-Match is absent (RFC 9110).
+func (ce cacheEntry) notModified(r *http.Request, etag string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || ce.modTime.IsZero() {
+		return false
+	}
 
-	dirEntries, err := os.ReadDir(fpath)
+	t, err := http.ParseTime(ims)
 	if err != nil {
-		return nil, fmt.Errorf("spa: failed to read directory %s: %w", fpath, err)
+		return false
 	}
 
-	for _, dirEntry := range dirEntries {
-		name := dirEntry.Name()
-		if strings.HasPrefix(name, ".") {
-			slog.Debug(fmt.Sprintf("spa: skipping file: %s", filepath.Join(fpath, name)))
-			continue
+	// Last-Modified only has second precision
+	return !ce.modTime.Truncate(time.Second).After(t)
+}
+
+// a directory tree to scan for files
+type scanRoot struct {
+	fsys fs.FS
+	// urlpath the root of fsys is served at ("" for /)
+	urlprefix string
+	// real (symlink-free) path of fsys on disk ("" if it isn't a directory)
+	realDir string
+	// real paths of the directories being scanned - the root, and any
+	// symlinked directories followed to get to this one
+	chain []string
+}
+
+// appends (and returns) cacheEntrys for every file found in root to slice,
+// stopping with ctx's error once it is done.
+// contents maps the etags of the files read so far to their content.
+func appendDirEntries(ctx context.Context, slice []cacheEntry, root scanRoot, c *config, contents map[string][]byte) ([]cacheEntry, error) {
+	err := fs.WalkDir(root.fsys, ".", func(fpath string, dirEntry fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
 
-		if strings.HasPrefix(name, "_") {
-			slog.Debug(fmt.Sprintf("spa: skipping file: %s", filepath.Join(fpath, name)))
-			continue
+		if err != nil {
+			if dirEntry == nil || dirEntry.IsDir() {
+				return fmt.Errorf("spa: failed to read directory %s: %w", fpath, err)
+			}
+			return fmt.Errorf("spa: failed to read %s: %w", fpath, err)
+		}
+
+		if fpath == "." {
+			c.logger.Debug(fmt.Sprintf("spa: reading directory: %s/", root.urlprefix))
+			return nil
+		}
+
+		// fs.FS paths are unrooted and always use forward slashes,
+		// so the urlpath is derived with path (not filepath)
+		urlpath := root.urlprefix + "/" + fpath
+
+		// everything under .well-known is served as-is - e.g. ACME challenge
+		// tokens are base64url, and so may well start with '_' or '-'
+		if !inWellKnown(urlpath) && c.shouldSkip(urlpath) {
+			c.logger.Debug(fmt.Sprintf("spa: skipping file: %s", fpath))
+			if dirEntry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
 		}
 
-		subFpath := filepath.Join(fpath, name)
-		subUpath := path.Join(urlpath, name)
 		if dirEntry.IsDir() {
-			slice, err = appendDirEntries(slice, subUpath, subFpath)
+			c.logger.Debug(fmt.Sprintf("spa: reading directory: %s", fpath))
+			return nil
+		}
+
+		if dirEntry.Type()&fs.ModeSymlink != 0 && root.realDir != "" {
+			slice, err = appendSymlinkEntries(ctx, slice, root, urlpath, fpath, c, contents)
+			return err
+		}
+
+		slice, err = appendFileEntry(slice, urlpath, root.fsys, fpath, c, contents)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slice, nil
+}
+
+// Reports whether urlpath is inside the top-level .well-known directory
+func inWellKnown(urlpath string) bool {
+	return strings.HasPrefix(urlpath, "/"+wellKnownDir+"/")
+}
+
+// appends (and returns) the cacheEntry for the file found at fpath in fsys to slice.
+// contents maps the etags of the files read so far to their content.
+func appendFileEntry(slice []cacheEntry, urlpath string, fsys fs.FS, fpath string, c *config, contents map[string][]byte) ([]cacheEntry, error) {
+	c.logger.Debug(fmt.Sprintf("spa: found file: %s", fpath))
+
+	ext := path.Ext(fpath)
+	ct := withCharset(mime.TypeByExtension(ext))
+	if override, ok := c.contentTypes[urlpath]; ok {
+		ct = override
+	}
+
+	fi, err := fs.Stat(fsys, fpath)
+	if err != nil {
+		return nil, fmt.Errorf("spa: failed to stat %s: %w", fpath, err)
+	}
+
+	var ce cacheEntry
+	if c.lazy {
+		// read (and sniffed, if need be) on its first request instead
+		ce = buildLazyEntry(urlpath, ct, fi.ModTime(), fsys, fpath)
+	} else if c.maxInMemoryBytes > 0 && fi.Size() > c.maxInMemoryBytes {
+		// too large to keep resident - hash it now and read it from fsys on each request
+		etag, err := hashFile(fsys, fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		if ct == "" {
+			ct, err = sniffContentType(fsys, fpath)
 			if err != nil {
 				return nil, err
 			}
-			continue
 		}
 
-		slice, err = appendFileEntry(slice, subUpath, subFpath)
+		ce = buildStreamedEntry(urlpath, ct, etag, fi.Size(), fi.ModTime(), fsys, fpath, c)
+		c.logger.Info(fmt.Sprintf("spa: streaming file %s (%s) (%d bytes) from disk", ce.urlpath, ce.contentType, ce.identitySize))
+	} else {
+		bs, err := fs.ReadFile(fsys, fpath)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("spa: failed to read %s: %w", fpath, err)
+		}
+
+		// e.g. extensionless files - better to sniff here than leave it to browsers
+		if ct == "" {
+			ct = http.DetectContentType(bs)
+		}
+
+		ce = buildCacheEntry(urlpath, ct, bs, fi.ModTime())
+
+		// byte-identical files (e.g. a favicon copied around by the build)
+		// share a single copy of their content
+		if shared, ok := contents[ce.etag]; ok {
+			c.logger.Debug(fmt.Sprintf("spa: %s is a duplicate, sharing its content", fpath))
+			ce = buildCacheEntry(urlpath, ct, shared, fi.ModTime())
+		} else {
+			contents[ce.etag] = bs
 		}
 	}
 
-	return slice, nil
+	ce.source = fsys
+	ce.fpath = fpath
+	if c.cacheControl != nil {
+		ce.cacheControl = c.cacheControl(urlpath)
+	}
+
+	return append(slice, ce), nil
 }
 
-// appends (and returns) cacheEntrys to found at fpath to slice
-func appendFileEntry(slice []cacheEntry, urlpath string, fpath string) ([]cacheEntry, error) {
-	slog.Debug(fmt.Sprintf("spa: found file: %s", fpath))
+// appends (and returns) entries for the default files configured in c that
+// aren't among entries (positions mapping their urlpaths to their indexes)
+func appendDefaultEntries(entries []cacheEntry, positions map[string]int, c *config) []cacheEntry {
+	defaults := []struct {
+		urlpath     string
+		contentType string
+		data        []byte
+	}{
+		{"/robots.txt", withCharset(mime.TypeByExtension(".txt")), c.defaultRobots},
+		{"/favicon.ico", mime.TypeByExtension(".ico"), c.defaultFavicon},
+	}
 
-	f, err := os.Open(fpath)
-	if err != nil {
-		return nil, fmt.Errorf("spa: failed to open %s: %w", fpath, err)
+	for _, d := range defaults {
+		if _, ok := positions[d.urlpath]; ok || d.data == nil {
+			continue
+		}
+
+		c.logger.Debug(fmt.Sprintf("spa: serving default %s", d.urlpath))
+		ce := buildCacheEntry(d.urlpath, d.contentType, d.data, time.Time{})
+		if c.cacheControl != nil {
+			ce.cacheControl = c.cacheControl(d.urlpath)
+		}
+
+		positions[d.urlpath] = len(entries)
+		entries = append(entries, ce)
 	}
-	defer f.Close()
 
-	ext := filepath.Ext(fpath)
-	ct := mime.TypeByExtension(ext)
+	return entries
+}
 
-	bs, err := io.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("spa: failed to read %s: %w", fpath, err)
+// returns the (uncompressed) cacheEntry serving data at urlpath
+func buildCacheEntry(urlpath string, contentType string, data []byte, modTime time.Time) cacheEntry {
+	// never serve without a Content-Type (least of all compressed), which
+	// would leave browsers to sniff
+	if contentType == "" {
+		contentType = defaultContentType
 	}
 
+	sum := sha256.Sum256(data)
 	ce := cacheEntry{
 		urlpath:        urlpath,
-		contentType:    ct,
-		identitySize:   len(bs),
+		contentType:    contentType,
+		etag:           formatETag(sum[:]),
+		modTime:        modTime,
+		identitySize:   len(data),
+		identity:       data,
 		compressedSize: -1,
+		brotliSize:     -1,
 	}
 
-	ce.identityHandler = func(wr http.ResponseWriter) {
-		wr.Header().Add("Content-Type", ce.contentType)
-		_, err := io.Copy(wr, bytes.NewReader(bs))
-		if err != nil {
-			slog.Error("spa: error serving %s: %w", ce.urlpath, err)
-			wr.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	ce.identityHandler = func(wr http.ResponseWriter, r *http.Request) {
+		// ServeContent takes care of Range, If-Range, and 206 Partial Content
+		wr.Header().Set("Content-Type", ce.contentType)
+		http.ServeContent(wr, r, ce.urlpath, ce.modTime, bytes.NewReader(data))
+	}
 
-		wr.WriteHeader(http.StatusOK)
+	return ce
+}
+
+// returns the strong entity tag (including quotes) for a content digest
+func formatETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// returns the entity tag for the file at fpath in fsys without holding its content in memory
+func hashFile(fsys fs.FS, fpath string) (string, error) {
+	f, err := fsys.Open(fpath)
+	if err != nil {
+		return "", fmt.Errorf("spa: failed to open %s: %w", fpath, err)
 	}
+	defer f.Close()
 
-	var gbsb bytes.Buffer
-	err = func() error {
-		wr, err := gzip.NewWriterLevel(&gbsb, gzip.BestCompression)
-		if err != nil {
-			return fmt.Errorf("spa: error creating gzip compressor: %w", err)
-		}
-		defer wr.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("spa: failed to read %s: %w", fpath, err)
+	}
 
-		_, err = io.Copy(wr, bytes.NewReader(bs))
-		if err != nil {
-			return fmt.Errorf("spa: error writing gzipped content: %w", err)
-		}
+	return formatETag(h.Sum(nil)), nil
+}
 
-		return nil
-	}()
+// returns the content type of the file at fpath in fsys, as sniffed from its
+// first bytes by [http.DetectContentType] (application/octet-stream if unknown)
+func sniffContentType(fsys fs.FS, fpath string) (string, error) {
+	f, err := fsys.Open(fpath)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("spa: failed to open %s: %w", fpath, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("spa: failed to read %s: %w", fpath, err)
 	}
 
-	gbs := gbsb.Bytes()
-	compressedSize := len(gbs)
-	ce.shouldServeCompressed = (ce.identitySize / tcpPacketDataSize) > (ce.compressedSize / tcpPacketDataSize)
+	return http.DetectContentType(buf[:n]), nil
+}
 
-	if contentTypeIsAlreadyCompressed(ce.contentType) {
-		ce.shouldServeCompressed = false
+// returns the cacheEntry serving the file at fpath in fsys (of size bytes,
+// with the given etag) at urlpath, which is read from fsys on each request
+func buildStreamedEntry(urlpath string, contentType string, etag string, size int64, modTime time.Time, fsys fs.FS, fpath string, c *config) cacheEntry {
+	if contentType == "" {
+		contentType = defaultContentType
 	}
 
-	if ce.shouldServeCompressed {
-		ce.compressedSize = compressedSize
-		ce.gzipHandler = func(wr http.ResponseWriter) {
-			wr.Header().Add("Content-Type", ce.contentType)
-			wr.Header().Add("Content-Encoding", "gzip")
-			_, err := io.Copy(wr, bytes.NewReader(gbs))
-			if err != nil {
-				slog.Error("spa: error serving %s (gzipped): %w", ce.urlpath, err)
-				wr.WriteHeader(http.StatusInternalServerError)
-				return
+	ce := cacheEntry{
+		urlpath:        urlpath,
+		contentType:    contentType,
+		etag:           etag,
+		modTime:        modTime,
+		identitySize:   int(size),
+		streamed:       true,
+		compressedSize: -1,
+		brotliSize:     -1,
+	}
+
+	ce.identityHandler = streamedHandler(&ce, fsys, fpath, c)
+	return ce
+}
+
+// returns a handler that serves the file at fpath in fsys (the content of ce),
+// opening it anew for each request - unless it is in the hot cache
+func streamedHandler(ce *cacheEntry, fsys fs.FS, fpath string, c *config) func(wr http.ResponseWriter, r *http.Request) {
+	logger := c.logger
+	return func(wr http.ResponseWriter, r *http.Request) {
+		// the file may have been deleted since it was scanned (e.g. by a deploy
+		// that's still in progress), which is the client's 404 rather than ours
+		gone := func(err error) {
+			logger.Warn("spa: file is gone since it was scanned", "path", ce.urlpath, "file", fpath, "err", err)
+			if c.evict != nil {
+				c.evict(ce)
 			}
 
-			wr.WriteHeader(http.StatusOK)
+			// these describe the file that was, not this response
+			delRepresentationHeaders(wr.Header())
+			writeError(wr, r, http.StatusNotFound, c.errorHandler)
 		}
-	}
 
-	slog.Info(fmt.Sprintf("spa: cached file %s (%s) (%d bytes, %d compressed)", ce.urlpath, ce.contentType, ce.identitySize, ce.compressedSize))
-	return append(slice, ce), nil
-}
+		if c.hotCache != nil && c.hotCache.fits(int64(ce.identitySize)) {
+			bs, ok := c.hotCache.get(ce.etag)
+			if !ok {
+				// read outside of any lock - concurrent misses may read the file more than once
+				var err error
+				bs, err = fs.ReadFile(fsys, fpath)
+				if errors.Is(err, fs.ErrNotExist) {
+					gone(err)
+					return
+				}
+				if err != nil {
+					logger.Error("spa: error reading file", "path", ce.urlpath, "file", fpath, "err", err)
+					writeError(wr, r, http.StatusInternalServerError, c.errorHandler)
+					return
+				}
 
-// Reports whether the content type supports compression as part of its encoding.
-// This can be used to prevent double-compressing content.
-//
-// TODO(a-jentleman) this list is not complete - if fact, it is not even close
-func contentTypeIsAlreadyCompressed(contentType string) bool {
-	switch contentType {
-	case "image/jpeg", "image/png", "image/gif", "audio/mpeg", "video/mp4":
-		return true
-	default:
-		return false
+				c.hotCache.add(ce.etag, bs)
+			}
+
+			wr.Header().Set("Content-Type", ce.contentType)
+			http.ServeContent(wr, r, ce.urlpath, ce.modTime, bytes.NewReader(bs))
+			return
+		}
+
+		f, err := fsys.Open(fpath)
+		if errors.Is(err, fs.ErrNotExist) {
+			gone(err)
+			return
+		}
+		if err != nil {
+			logger.Error("spa: error opening file", "path", ce.urlpath, "file", fpath, "err", err)
+			writeError(wr, r, http.StatusInternalServerError, c.errorHandler)
+			return
+		}
+		defer f.Close()
+
+		wr.Header().Set("Content-Type", ce.contentType)
+
+		if rs, ok := f.(io.ReadSeeker); ok {
+			http.ServeContent(wr, r, ce.urlpath, ce.modTime, rs)
+			return
+		}
+
+		// can't seek, so can't serve ranges either
+		wr.Header().Set("Accept-Ranges", "none")
+		wr.Header().Set("Content-Length", strconv.Itoa(ce.identitySize))
+		wr.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		_, err = io.Copy(wr, f)
+		if err != nil {
+			logServeError(logger, r, err, "path", ce.urlpath)
+		}
 	}
 }
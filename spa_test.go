@@ -0,0 +1,1394 @@
+package spa
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// text that compresses well, and is large enough (several TCP packets) to be
+// worth serving compressed
+var compressible = strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200)
+
+// writes files (keyed by slash-separated path) into a new temporary directory,
+// and returns the directory
+func writeTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// a logger that drops everything, so that tests don't log every cached file
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// a [slog.Handler] that records everything logged through it, at any level
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (rh *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (rh *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return rh }
+func (rh *recordingHandler) WithGroup(string) slog.Handler            { return rh }
+
+func (rh *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.records = append(rh.records, r.Clone())
+	return nil
+}
+
+// returns the first record logged with msg, and its attributes by key
+func (rh *recordingHandler) find(msg string) (slog.Record, map[string]slog.Value, bool) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	for _, r := range rh.records {
+		if r.Message != msg {
+			continue
+		}
+
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return r, attrs, true
+	}
+
+	return slog.Record{}, nil, false
+}
+
+// returns a handler serving files (see writeTree), configured by opts
+func newTestHandler(t testing.TB, files map[string]string, opts ...Option) *Handler {
+	t.Helper()
+
+	h, err := NewHandlerWithOptions(writeTree(t, files), append([]Option{WithLogger(discardLogger)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// an [fs.FS] that counts how many times each file is opened
+type countingFS struct {
+	fs.FS
+
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (cfs *countingFS) Open(name string) (fs.File, error) {
+	cfs.mu.Lock()
+	if cfs.opens == nil {
+		cfs.opens = make(map[string]int)
+	}
+	cfs.opens[name]++
+	cfs.mu.Unlock()
+
+	return cfs.FS.Open(name)
+}
+
+// returns the number of times the file at name has been opened
+func (cfs *countingFS) count(name string) int {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+	return cfs.opens[name]
+}
+
+// returns h's response to a method request for target, with headers given
+// as name, value pairs
+func serve(h http.Handler, method string, target string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	wr := httptest.NewRecorder()
+	h.ServeHTTP(wr, r)
+	return wr
+}
+
+// a [http.ResponseWriter] that counts the calls to WriteHeader, including
+// the implicit one made by the first Write
+type headerCountingWriter struct {
+	*httptest.ResponseRecorder
+	writeHeaders int
+	wroteBody    bool
+	lateHeader   bool
+	// number of calls to Write
+	writes int
+}
+
+func (hw *headerCountingWriter) WriteHeader(status int) {
+	hw.writeHeaders++
+	if hw.wroteBody {
+		hw.lateHeader = true
+	}
+	hw.ResponseRecorder.WriteHeader(status)
+}
+
+func (hw *headerCountingWriter) Write(bs []byte) (int, error) {
+	if hw.writeHeaders == 0 {
+		hw.WriteHeader(http.StatusOK)
+	}
+	hw.wroteBody = true
+	hw.writes++
+	return hw.ResponseRecorder.Write(bs)
+}
+
+func TestServeWritesHeaderOnce(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"small.txt":  "hello",
+	})
+
+	for _, tt := range []struct {
+		path           string
+		acceptEncoding string
+	}{
+		{"/index.html", ""},
+		{"/index.html", "gzip"},
+		{"/index.html", "br"},
+		{"/small.txt", ""},
+	} {
+		wr := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		h.ServeHTTP(wr, r)
+
+		if wr.Code != http.StatusOK {
+			t.Errorf("%s (%q): got status %d, want %d", tt.path, tt.acceptEncoding, wr.Code, http.StatusOK)
+		}
+		if wr.writeHeaders != 1 || wr.lateHeader {
+			t.Errorf("%s (%q): WriteHeader called %d times (after the body: %v), want once before it", tt.path, tt.acceptEncoding, wr.writeHeaders, wr.lateHeader)
+		}
+	}
+}
+
+// Every response either has a Content-Length or writes its body in one piece,
+// so buffering writes (as net/http's own connection buffer already does for
+// small ones) would never coalesce anything.
+func TestServeBodiesNeedNoBuffering(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html":   compressible,
+		"docs/a.txt":   "a",
+		"video.mp4":    incompressible(256 << 10),
+		"assets/a.css": "body{}",
+	}, WithMaxInMemoryBytes(32<<10), WithHealthPath("/healthz"), WithDirectoryListing(), WithSPAFallback(false))
+
+	for _, tt := range []struct {
+		method  string
+		path    string
+		headers []string
+	}{
+		{http.MethodGet, "/index.html", nil},
+		{http.MethodGet, "/index.html", []string{"Accept-Encoding", "gzip"}},
+		{http.MethodGet, "/index.html", []string{"Accept-Encoding", "br"}},
+		{http.MethodGet, "/video.mp4", nil},
+		{http.MethodGet, "/video.mp4", []string{"Range", "bytes=0-99,200-299"}},
+		{http.MethodGet, "/healthz", nil},
+		{http.MethodGet, "/docs/", nil},
+		{http.MethodGet, "/missing.js", nil},
+		{http.MethodPost, "/index.html", nil},
+		{http.MethodGet, "/index.html", []string{"Accept-Encoding", "identity;q=0"}},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		for i := 0; i+1 < len(tt.headers); i += 2 {
+			r.Header.Set(tt.headers[i], tt.headers[i+1])
+		}
+		wr := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+		h.ServeHTTP(wr, r)
+
+		if wr.Header().Get("Content-Length") == "" && wr.writes > 1 {
+			t.Errorf("%s %s %q: got status %d written in %d pieces without a Content-Length", tt.method, tt.path, tt.headers, wr.Code, wr.writes)
+		}
+	}
+}
+
+func TestServeSetsContentLength(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+
+	for _, acceptEncoding := range []string{"", "gzip", "br"} {
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", acceptEncoding)
+
+		if got, want := wr.Header().Get("Content-Length"), strconv.Itoa(wr.Body.Len()); got != want {
+			t.Errorf("%q: got Content-Length %q, want %q", acceptEncoding, got, want)
+		}
+		if acceptEncoding != "" && wr.Header().Get("Content-Encoding") != acceptEncoding {
+			t.Errorf("%q: got Content-Encoding %q", acceptEncoding, wr.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestServeVaryAcceptEncoding(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"small.txt":  "hello",
+	})
+
+	// both the compressed and identity branches, so that caches key on it
+	for _, acceptEncoding := range []string{"gzip", ""} {
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", acceptEncoding)
+		if got := wr.Header().Values("Vary"); !slices.Contains(got, "Accept-Encoding") {
+			t.Errorf("%q: got Vary %q, want Accept-Encoding", acceptEncoding, got)
+		}
+	}
+
+	// content that is never compressed doesn't vary
+	wr := serve(h, http.MethodGet, "/small.txt", "Accept-Encoding", "gzip")
+	if got := wr.Header().Values("Vary"); len(got) != 0 {
+		t.Errorf("uncompressed: got Vary %q, want none", got)
+	}
+}
+
+func TestServeFallbackCompressed(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+	index := h.entries()["/index.html"]
+
+	for _, tt := range []struct {
+		encoding string
+		size     int
+	}{
+		{"gzip", index.compressedSize},
+		{"br", index.brotliSize},
+	} {
+		wr := serve(h, http.MethodGet, "/users/42", "Accept", "text/html", "Accept-Encoding", tt.encoding)
+		if wr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", tt.encoding, wr.Code, http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: got Content-Encoding %q", tt.encoding, got)
+		}
+		if got := wr.Header().Values("Vary"); !slices.Contains(got, "Accept-Encoding") {
+			t.Errorf("%s: got Vary %q, want Accept-Encoding", tt.encoding, got)
+		}
+		if got, want := wr.Header().Get("Content-Length"), strconv.Itoa(tt.size); got != want || wr.Body.Len() != tt.size {
+			t.Errorf("%s: got Content-Length %s with %d bytes, want %s", tt.encoding, got, wr.Body.Len(), want)
+		}
+		if got := decode(t, tt.encoding, wr.Body); got != compressible {
+			t.Errorf("%s: got different content than the index", tt.encoding)
+		}
+	}
+
+	// and through a real server, which would notice a wrong Content-Length
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	r, err := http.NewRequest(http.MethodGet, srv.URL+"/users/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	// so that the client doesn't transparently decompress the body
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.ContentLength != int64(index.compressedSize) {
+		t.Errorf("server: got Content-Encoding %q with Content-Length %d, want gzip with %d", resp.Header.Get("Content-Encoding"), resp.ContentLength, index.compressedSize)
+	}
+	if got := decode(t, "gzip", resp.Body); got != compressible {
+		t.Error("server: got different content than the index")
+	}
+}
+
+func TestServeHead(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		get := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", acceptEncoding)
+		head := serve(h, http.MethodHead, "/index.html", "Accept-Encoding", acceptEncoding)
+
+		if head.Code != http.StatusOK {
+			t.Errorf("%q: got status %d, want %d", acceptEncoding, head.Code, http.StatusOK)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("%q: got a %d byte body, want none", acceptEncoding, head.Body.Len())
+		}
+		for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding"} {
+			if got, want := head.Header().Get(name), get.Header().Get(name); got != want {
+				t.Errorf("%q: got %s %q, want %q (as for GET)", acceptEncoding, name, got, want)
+			}
+		}
+	}
+}
+
+func TestServeMethods(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"})
+
+	for _, tt := range []struct {
+		method string
+		status int
+		allow  string
+	}{
+		{http.MethodGet, http.StatusOK, ""},
+		{http.MethodPost, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPut, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodOptions, http.StatusNoContent, "GET, HEAD"},
+	} {
+		wr := serve(h, tt.method, "/index.html")
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.method, wr.Code, tt.status)
+		}
+		if got := wr.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s: got Allow %q, want %q", tt.method, got, tt.allow)
+		}
+		if tt.status != http.StatusOK && wr.Body.String() == "<html></html>" {
+			t.Errorf("%s: got the file's content", tt.method)
+		}
+	}
+}
+
+func TestServeMissingAsset(t *testing.T) {
+	files := map[string]string{"index.html": "<html>index</html>"}
+
+	const (
+		navigation  = "text/html,application/xhtml+xml"
+		subresource = "*/*"
+	)
+
+	for _, tt := range []struct {
+		name   string
+		opts   []Option
+		path   string
+		accept string
+		status int
+	}{
+		{"missing asset", nil, "/app.js", subresource, http.StatusNotFound},
+		{"dotted route", nil, "/users/jane.doe", navigation, http.StatusOK},
+		{"extensionless route", nil, "/users/jane", navigation, http.StatusOK},
+		{"missing asset without navigation fallback", []Option{WithNavigationFallback(false)}, "/app.js", subresource, http.StatusNotFound},
+		{"dotted route without navigation fallback", []Option{WithNavigationFallback(false)}, "/users/jane.doe", navigation, http.StatusOK},
+		{"extensionless route without navigation fallback", []Option{WithNavigationFallback(false)}, "/users/jane", subresource, http.StatusOK},
+		{"missing asset allowed", []Option{WithNavigationFallback(false), WithAssetNotFound(false)}, "/app.js", subresource, http.StatusOK},
+	} {
+		h := newTestHandler(t, files, tt.opts...)
+
+		wr := serve(h, http.MethodGet, tt.path, "Accept", tt.accept)
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && wr.Body.String() != files["index.html"] {
+			t.Errorf("%s: got %q, want the index", tt.name, wr.Body.String())
+		}
+	}
+}
+
+func TestServeSourceMaps(t *testing.T) {
+	files := map[string]string{
+		"index.html":         "<html>index</html>",
+		"assets/app.js":      "console.log(1)\n//# sourceMappingURL=app.js.map",
+		"assets/app.js.map":  `{"version":3,"sources":["app.ts"],"mappings":"AAAA"}`,
+		"assets/app.css.map": `{"version":3,"sources":["app.scss"],"mappings":"AAAA"}`,
+	}
+	h := newTestHandler(t, files)
+
+	for _, urlpath := range []string{"/assets/app.js.map", "/assets/app.css.map"} {
+		wr := serve(h, http.MethodGet, urlpath)
+		if wr.Code != http.StatusOK || wr.Body.String() != files[urlpath[1:]] {
+			t.Errorf("%s: got status %d with %q, want %d with the map", urlpath, wr.Code, wr.Body.String(), http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q, want application/json", urlpath, got)
+		}
+		if _, ok := h.entries()[urlpath]; !ok {
+			t.Errorf("%s isn't cached", urlpath)
+		}
+	}
+
+	// devtools fetch maps as subresources, and choke on the index's HTML
+	for _, opts := range [][]Option{nil, {WithNavigationFallback(false)}} {
+		h := newTestHandler(t, files, opts...)
+		for _, accept := range []string{"", "*/*", "application/json"} {
+			wr := serve(h, http.MethodGet, "/assets/missing.js.map", "Accept", accept)
+			if wr.Code != http.StatusNotFound || strings.Contains(wr.Body.String(), "<html>") {
+				t.Errorf("missing map (Accept %q): got status %d with %q, want %d", accept, wr.Code, wr.Body.String(), http.StatusNotFound)
+			}
+		}
+	}
+}
+
+func TestServeIfNoneMatch(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"})
+
+	etag := serve(h, http.MethodGet, "/index.html").Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) != 66 {
+		t.Fatalf("got ETag %q, want a quoted SHA-256", etag)
+	}
+
+	wr := serve(h, http.MethodGet, "/index.html", "If-None-Match", etag)
+	if wr.Code != http.StatusNotModified || wr.Body.Len() != 0 {
+		t.Errorf("matching: got status %d with %d bytes, want %d without a body", wr.Code, wr.Body.Len(), http.StatusNotModified)
+	}
+	if got := wr.Header().Get("ETag"); got != etag {
+		t.Errorf("matching: got ETag %q, want %q", got, etag)
+	}
+
+	wr = serve(h, http.MethodGet, "/index.html", "If-None-Match", `"stale"`)
+	if wr.Code != http.StatusOK || wr.Body.String() != "<html></html>" {
+		t.Errorf("not matching: got status %d with %q, want %d with the content", wr.Code, wr.Body.String(), http.StatusOK)
+	}
+}
+
+func TestServeETagPerEncoding(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+
+	etags := make(map[string]string)
+	for _, encoding := range []string{"", "gzip", "br"} {
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", encoding)
+		if got := wr.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("%q: got Content-Encoding %q", encoding, got)
+		}
+		etags[encoding] = wr.Header().Get("ETag")
+	}
+
+	if etags[""] == etags["gzip"] || etags[""] == etags["br"] || etags["gzip"] == etags["br"] {
+		t.Errorf("got ETags %q, want one for each encoding", etags)
+	}
+	if want := strings.TrimSuffix(etags[""], `"`) + `-gzip"`; etags["gzip"] != want {
+		t.Errorf("got gzip ETag %s, want %s", etags["gzip"], want)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		acceptEncoding string
+		ifNoneMatch    string
+		status         int
+	}{
+		{"identity", "", etags[""], http.StatusNotModified},
+		{"gzip", "gzip", etags["gzip"], http.StatusNotModified},
+		{"brotli", "br", etags["br"], http.StatusNotModified},
+		{"weak gzip", "gzip", "W/" + etags["gzip"], http.StatusNotModified},
+		{"gzip tag for identity", "", etags["gzip"], http.StatusOK},
+		{"identity tag for gzip", "gzip", etags[""], http.StatusOK},
+		{"brotli tag for gzip", "gzip", etags["br"], http.StatusOK},
+		{"either tag for gzip", "gzip", etags[""] + ", " + etags["gzip"], http.StatusNotModified},
+	} {
+		wr := serve(h, http.MethodGet, "/index.html", "Accept-Encoding", tt.acceptEncoding, "If-None-Match", tt.ifNoneMatch)
+		if wr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, wr.Code, tt.status)
+		}
+		if got, want := wr.Header().Get("ETag"), etags[tt.acceptEncoding]; got != want {
+			t.Errorf("%s: got ETag %s, want %s", tt.name, got, want)
+		}
+	}
+}
+
+func TestServeIfModifiedSince(t *testing.T) {
+	dir := writeTree(t, map[string]string{"index.html": "<html></html>"})
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "index.html"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHandlerWithOptions(dir, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wr := serve(h, http.MethodGet, "/index.html")
+	if got, want := wr.Header().Get("Last-Modified"), modTime.Format(http.TimeFormat); got != want {
+		t.Errorf("got Last-Modified %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		since  time.Time
+		status int
+	}{
+		{modTime, http.StatusNotModified},
+		{modTime.Add(time.Hour), http.StatusNotModified},
+		{modTime.Add(-time.Hour), http.StatusOK},
+	} {
+		wr := serve(h, http.MethodGet, "/index.html", "If-Modified-Since", tt.since.Format(http.TimeFormat))
+		if wr.Code != tt.status {
+			t.Errorf("since %v: got status %d, want %d", tt.since, wr.Code, tt.status)
+		}
+	}
+}
+
+func TestNewHandlerFS(t *testing.T) {
+	// like an embed.FS: slash-separated paths, with no leading slash
+	fsys := fstest.MapFS{
+		"index.html":                {Data: []byte("<html></html>")},
+		"assets/app.js":             {Data: []byte(compressible)},
+		"assets/css/site.css":       {Data: []byte("body{}")},
+		"assets/img/icons/logo.svg": {Data: []byte("<svg></svg>")},
+		"docs/index.html":           {Data: []byte("<html>docs</html>")},
+	}
+
+	h, err := NewHandlerFS(fsys, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path        string
+		body        string
+		contentType string
+	}{
+		{"/assets/app.js", compressible, "text/javascript; charset=utf-8"},
+		{"/assets/css/site.css", "body{}", "text/css; charset=utf-8"},
+		{"/assets/img/icons/logo.svg", "<svg></svg>", "image/svg+xml; charset=utf-8"},
+		{"/docs/", "<html>docs</html>", "text/html; charset=utf-8"},
+		{"/", "<html></html>", "text/html; charset=utf-8"},
+		// unknown routes get the index, however deep
+		{"/users/42/settings", "<html></html>", "text/html; charset=utf-8"},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != http.StatusOK || wr.Body.String() != tt.body {
+			t.Errorf("%s: got status %d with %q, want %d with %q", tt.path, wr.Code, wr.Body.String(), http.StatusOK, tt.body)
+		}
+		if got := wr.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.contentType)
+		}
+	}
+
+	if wr := serve(h, http.MethodGet, "/assets/app.js", "Accept-Encoding", "gzip"); decode(t, wr.Header().Get("Content-Encoding"), wr.Body) != compressible || wr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("gzip: got Content-Encoding %q", wr.Header().Get("Content-Encoding"))
+	}
+	if wr := serve(h, http.MethodGet, "/assets/missing.js", "Accept", "*/*"); wr.Code != http.StatusNotFound {
+		t.Errorf("missing asset: got status %d, want %d", wr.Code, http.StatusNotFound)
+	}
+}
+
+func TestServeZeroModTime(t *testing.T) {
+	// like an embed.FS, which reports no modification times
+	h, err := NewHandlerFS(fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wr := serve(h, http.MethodGet, "/index.html")
+	if got := wr.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("got Last-Modified %q, want none", got)
+	}
+
+	wr = serve(h, http.MethodGet, "/index.html", "If-Modified-Since", time.Now().Format(http.TimeFormat))
+	if wr.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", wr.Code, http.StatusOK)
+	}
+}
+
+func TestServeStreamed(t *testing.T) {
+	large := incompressible(64 << 10)
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		"video.mp4":  large,
+	}, WithMaxInMemoryBytes(32<<10))
+
+	entry := h.entries()["/video.mp4"]
+	if !entry.streamed || entry.identity != nil {
+		t.Fatalf("got an entry holding %d bytes in memory, want it streamed", len(entry.identity))
+	}
+	if index := h.entries()["/index.html"]; index.streamed || index.identity == nil {
+		t.Errorf("small file is streamed, want it held in memory")
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		wr := serve(h, method, "/video.mp4")
+		if wr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", method, wr.Code, http.StatusOK)
+		}
+		if got, want := wr.Header().Get("Content-Length"), strconv.Itoa(len(large)); got != want {
+			t.Errorf("%s: got Content-Length %q, want %q", method, got, want)
+		}
+		if got := wr.Header().Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("%s: got Content-Type %q, want video/mp4", method, got)
+		}
+		if method == http.MethodGet && wr.Body.String() != large {
+			t.Errorf("%s: got %d bytes, want the file's %d", method, wr.Body.Len(), len(large))
+		}
+	}
+}
+
+func TestServeDeletedFile(t *testing.T) {
+	files := map[string]string{
+		"index.html": "<html></html>",
+		"video.mp4":  incompressible(64 << 10),
+		"app.js":     "console.log(1)",
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+		new  func(dir string, opts ...Option) (*Handler, error)
+		opts []Option
+	}{
+		{"streamed", "/video.mp4", NewHandlerWithOptions, []Option{WithMaxInMemoryBytes(32 << 10)}},
+		{"hot cache", "/video.mp4", NewHandlerWithOptions, []Option{WithMaxInMemoryBytes(32 << 10), WithHotCache(1 << 20)}},
+		{"lazy", "/app.js", NewLazyHandler, nil},
+	} {
+		dir := writeTree(t, files)
+		rec := &recordingHandler{}
+		h, err := tt.new(dir, append(tt.opts, WithLogger(slog.New(rec)))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// e.g. by a deploy that's still in progress
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(tt.path[1:]))); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range 2 {
+			wr := serve(h, http.MethodGet, tt.path, "Accept", "*/*")
+			if wr.Code != http.StatusNotFound {
+				t.Errorf("%s: request %d: got status %d, want %d", tt.name, i, wr.Code, http.StatusNotFound)
+			}
+			if got := wr.Header().Get("ETag"); got != "" {
+				t.Errorf("%s: request %d: got ETag %q, want none", tt.name, i, got)
+			}
+		}
+
+		if _, attrs, ok := rec.find("spa: file is gone since it was scanned"); !ok || attrs["path"].String() != tt.path {
+			t.Errorf("%s: got no warning for %s", tt.name, tt.path)
+		}
+		if _, ok := h.entries()[tt.path]; ok {
+			t.Errorf("%s: %s is still cached", tt.name, tt.path)
+		}
+
+		// and it's treated like any other missing file from then on
+		if wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html"); wr.Code != http.StatusOK || wr.Body.String() != files["index.html"] {
+			t.Errorf("%s: navigation: got status %d with %q, want the index", tt.name, wr.Code, wr.Body.String())
+		}
+	}
+
+	// the 404 page itself, which leaves a bare 404 for missing files
+	for _, tt := range []struct {
+		name string
+		new  func(dir string, opts ...Option) (*Handler, error)
+		opts []Option
+	}{
+		{"streamed 404 page", NewHandlerWithOptions, []Option{WithMaxInMemoryBytes(32 << 10)}},
+		{"lazy 404 page", NewLazyHandler, nil},
+	} {
+		dir := writeTree(t, map[string]string{
+			"index.html": "<html></html>",
+			"404.html":   "<html>not found</html>" + incompressible(64<<10),
+		})
+		h, err := tt.new(dir, append(tt.opts, WithLogger(discardLogger), WithNotFoundPage("/404.html"))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Remove(filepath.Join(dir, "404.html")); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range 3 {
+			wr := serve(h, http.MethodGet, "/missing.js", "Accept", "*/*")
+			if wr.Code != http.StatusNotFound || wr.Body.Len() != 0 {
+				t.Errorf("%s: request %d: got status %d with %d bytes, want a bare %d", tt.name, i, wr.Code, wr.Body.Len(), http.StatusNotFound)
+			}
+		}
+		if _, ok := h.entries()["/404.html"]; ok {
+			t.Errorf("%s: /404.html is still cached", tt.name)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"video.mp4":  incompressible(64 << 10),
+	}, WithMaxInMemoryBytes(32<<10))
+
+	for _, tt := range []struct {
+		path    string
+		content string
+	}{
+		{"/index.html", compressible},
+		{"/video.mp4", incompressible(64 << 10)},
+	} {
+		// even though the client would take it compressed
+		wr := serve(h, http.MethodGet, tt.path, "Range", "bytes=0-99", "Accept-Encoding", "gzip, br")
+		if wr.Code != http.StatusPartialContent {
+			t.Errorf("%s: got status %d, want %d", tt.path, wr.Code, http.StatusPartialContent)
+		}
+		if got, want := wr.Header().Get("Content-Range"), "bytes 0-99/"+strconv.Itoa(len(tt.content)); got != want {
+			t.Errorf("%s: got Content-Range %q, want %q", tt.path, got, want)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: got Content-Encoding %q, want none", tt.path, got)
+		}
+		if wr.Body.String() != tt.content[:100] {
+			t.Errorf("%s: got %q, want the first 100 bytes", tt.path, wr.Body.String())
+		}
+	}
+}
+
+func TestServeAcceptRanges(t *testing.T) {
+	files := map[string]string{
+		"index.html": compressible,
+		"video.mp4":  incompressible(64 << 10),
+	}
+	h := newTestHandler(t, files, WithMaxInMemoryBytes(32<<10))
+
+	// content read from an archive can't be seeked, so ranges of it can't be served
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewHandlerArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()), WithLogger(discardLogger), WithMaxInMemoryBytes(32<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		h              http.Handler
+		path           string
+		acceptEncoding string
+		want           string
+		// of a request for a range - which is served from the identity
+		// content, if it can be
+		rangeStatus int
+	}{
+		{"identity", h, "/index.html", "", "bytes", http.StatusPartialContent},
+		{"gzip", h, "/index.html", "gzip", "none", http.StatusPartialContent},
+		{"brotli", h, "/index.html", "br", "none", http.StatusPartialContent},
+		{"streamed", h, "/video.mp4", "gzip, br", "bytes", http.StatusPartialContent},
+		{"streamed unseekable", archive, "/video.mp4", "", "none", http.StatusOK},
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			wr := serve(tt.h, method, tt.path, "Accept-Encoding", tt.acceptEncoding)
+			if got := wr.Header().Get("Accept-Ranges"); got != tt.want {
+				t.Errorf("%s (%s): got Accept-Ranges %q, want %q", tt.name, method, got, tt.want)
+			}
+		}
+
+		wr := serve(tt.h, http.MethodGet, tt.path, "Accept-Encoding", tt.acceptEncoding, "Range", "bytes=0-99")
+		if wr.Code != tt.rangeStatus {
+			t.Errorf("%s: got status %d for a range, want %d", tt.name, wr.Code, tt.rangeStatus)
+		}
+	}
+}
+
+func TestServeIfRange(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": compressible,
+		"video.mp4":  incompressible(64 << 10),
+	}, WithMaxInMemoryBytes(32<<10))
+
+	for _, tt := range []struct {
+		path    string
+		content string
+	}{
+		{"/index.html", compressible},
+		{"/video.mp4", incompressible(64 << 10)},
+	} {
+		current := serve(h, http.MethodGet, tt.path).Header()
+
+		for _, ifRange := range []string{current.Get("ETag"), current.Get("Last-Modified")} {
+			wr := serve(h, http.MethodGet, tt.path, "Range", "bytes=0-99", "If-Range", ifRange)
+			if wr.Code != http.StatusPartialContent || wr.Body.String() != tt.content[:100] {
+				t.Errorf("%s: If-Range %s: got status %d with %d bytes, want %d with the first 100", tt.path, ifRange, wr.Code, wr.Body.Len(), http.StatusPartialContent)
+			}
+		}
+
+		// e.g. the file was replaced by a deploy since the client started
+		// downloading it, so the part it has is of something else
+		stale := []string{
+			`"stale"`,
+			"W/" + current.Get("ETag"),
+			time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+		}
+		for _, ifRange := range stale {
+			wr := serve(h, http.MethodGet, tt.path, "Range", "bytes=0-99", "If-Range", ifRange)
+			if wr.Code != http.StatusOK || wr.Body.String() != tt.content {
+				t.Errorf("%s: If-Range %s: got status %d with %d bytes, want %d with all %d", tt.path, ifRange, wr.Code, wr.Body.Len(), http.StatusOK, len(tt.content))
+			}
+			if got := wr.Header().Get("Content-Range"); got != "" {
+				t.Errorf("%s: If-Range %s: got Content-Range %q, want none", tt.path, ifRange, got)
+			}
+		}
+	}
+
+	// as a full response, a stale one can be compressed
+	wr := serve(h, http.MethodGet, "/index.html", "Range", "bytes=0-99", "If-Range", `"stale"`, "Accept-Encoding", "gzip")
+	if got := wr.Header().Get("Content-Encoding"); wr.Code != http.StatusOK || got != "gzip" || decode(t, "gzip", wr.Body) != compressible {
+		t.Errorf("gzip: got status %d with Content-Encoding %q, want %d with gzip", wr.Code, got, http.StatusOK)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	global := &recordingHandler{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(global))
+
+	injected := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"}, WithLogger(slog.New(injected)))
+	serve(h, http.MethodGet, "/index.html")
+
+	for _, msg := range []string{"spa: initializing handler", "spa: request for /index.html (original: /index.html)"} {
+		if _, _, ok := injected.find(msg); !ok {
+			t.Errorf("%q wasn't logged through the injected logger", msg)
+		}
+	}
+	if len(global.records) != 0 {
+		t.Errorf("got %d records through the default logger, want none", len(global.records))
+	}
+}
+
+// a [http.ResponseWriter] whose body writes fail with err
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (fw *failingWriter) Write([]byte) (int, error) {
+	return 0, fw.err
+}
+
+func TestServeErrorAttributes(t *testing.T) {
+	rec := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithLogger(slog.New(rec)))
+
+	// (identity content is served by http.ServeContent, which drops write errors)
+	writeErr := errors.New("disk on fire")
+	for _, acceptEncoding := range []string{"gzip", "br"} {
+		rec.records = nil
+
+		r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		h.ServeHTTP(&failingWriter{ResponseRecorder: httptest.NewRecorder(), err: writeErr}, r)
+
+		record, attrs, ok := rec.find("spa: error serving file")
+		if !ok {
+			t.Fatalf("%q: no error logged", acceptEncoding)
+		}
+		if record.Level != slog.LevelError {
+			t.Errorf("%q: logged at %v, want %v", acceptEncoding, record.Level, slog.LevelError)
+		}
+		if got := attrs["path"].String(); got != "/index.html" {
+			t.Errorf("%q: got path %q, want /index.html", acceptEncoding, got)
+		}
+		if err, _ := attrs["err"].Any().(error); !errors.Is(err, writeErr) {
+			t.Errorf("%q: got err %v, want %v", acceptEncoding, attrs["err"], writeErr)
+		}
+		for key := range attrs {
+			if strings.Contains(key, "%") {
+				t.Errorf("%q: got attribute %q, a printf verb", acceptEncoding, key)
+			}
+		}
+	}
+}
+
+// a [http.ResponseWriter] that takes n bytes of the body, then fails with err
+type disconnectingWriter struct {
+	*headerCountingWriter
+	n   int
+	err error
+}
+
+func (dw *disconnectingWriter) Write(bs []byte) (int, error) {
+	if len(bs) <= dw.n {
+		dw.n -= len(bs)
+		return dw.headerCountingWriter.Write(bs)
+	}
+
+	n, _ := dw.headerCountingWriter.Write(bs[:dw.n])
+	dw.n = 0
+	return n, dw.err
+}
+
+func TestServeClientGone(t *testing.T) {
+	rec := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{"index.html": compressible}, WithLogger(slog.New(rec)))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"broken pipe", context.Background(), &os.SyscallError{Syscall: "write", Err: syscall.EPIPE}},
+		{"connection reset", context.Background(), fmt.Errorf("write tcp: %w", syscall.ECONNRESET)},
+		{"aborted", context.Background(), http.ErrAbortHandler},
+		{"cancelled request", cancelled, errors.New("i/o timeout")},
+	} {
+		for _, acceptEncoding := range []string{"gzip", "br"} {
+			rec.records = nil
+
+			r := httptest.NewRequest(http.MethodGet, "/index.html", nil).WithContext(tt.ctx)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			wr := &disconnectingWriter{headerCountingWriter: &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}, n: 10, err: tt.err}
+			h.ServeHTTP(wr, r)
+
+			// the status was sent with the first bytes, so is left as it was
+			if wr.writeHeaders != 1 || wr.Code != http.StatusOK {
+				t.Errorf("%s (%s): got %d WriteHeader calls with status %d, want 1 with %d", tt.name, acceptEncoding, wr.writeHeaders, wr.Code, http.StatusOK)
+			}
+
+			if _, _, ok := rec.find("spa: error serving file"); ok {
+				t.Errorf("%s (%s): logged an error", tt.name, acceptEncoding)
+			}
+			record, attrs, ok := rec.find("spa: client went away mid-response")
+			if !ok || record.Level != slog.LevelDebug {
+				t.Errorf("%s (%s): got no debug record", tt.name, acceptEncoding)
+			} else if err, _ := attrs["err"].Any().(error); !errors.Is(err, tt.err) {
+				t.Errorf("%s (%s): got err %v, want %v", tt.name, acceptEncoding, attrs["err"], tt.err)
+			}
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"index.html": "<html></html>",
+		"app.js":     "console.log(1)",
+	})
+	h, err := NewHandlerWithOptions(dir, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "new.js"), []byte("console.log(2)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for urlpath, want := range map[string]string{"/app.js": "console.log(1)", "/new.js": "console.log(2)"} {
+		wr := serve(h, http.MethodGet, urlpath)
+		if wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %q", urlpath, wr.Code, wr.Body.String(), want)
+		}
+	}
+
+	// a failed reload (here, for want of the index) keeps the previous cache
+	if err := os.Remove(filepath.Join(dir, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reload(); err == nil {
+		t.Error("got no error reloading without an index")
+	}
+	if wr := serve(h, http.MethodGet, "/index.html"); wr.Code != http.StatusOK || wr.Body.String() != "<html></html>" {
+		t.Errorf("after a failed reload: got status %d with %q, want the previous index", wr.Code, wr.Body.String())
+	}
+}
+
+func TestServeDirectoryIndex(t *testing.T) {
+	files := map[string]string{
+		"index.html":      "<html>root</html>",
+		"docs/index.html": "<html>docs</html>",
+		"blog/post.html":  "<html>post</html>",
+	}
+	h := newTestHandler(t, files)
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"/docs/", files["docs/index.html"]},
+		{"/docs", files["docs/index.html"]},
+		{"/blog/", files["index.html"]},
+		{"/blog", files["index.html"]},
+		{"/", files["index.html"]},
+	} {
+		wr := serve(h, http.MethodGet, tt.path, "Accept", "text/html")
+		if wr.Code != http.StatusOK || wr.Body.String() != tt.want {
+			t.Errorf("%s: got status %d with %q, want %q", tt.path, wr.Code, wr.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestServeInvalidPath(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"})
+
+	for _, target := range []string{
+		"/..%2F..%2Fetc%2Fpasswd",
+		"/assets/../index.html",
+		"/assets/..%2f..%2f..%2fetc/passwd",
+		"/index.html%00.js",
+		"/%00",
+		"/..%5C..%5Cwindows%5Cwin.ini",
+	} {
+		wr := serve(h, http.MethodGet, target, "Accept", "text/html")
+		if wr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", target, wr.Code, http.StatusBadRequest)
+		}
+		if wr.Body.String() == "<html></html>" {
+			t.Errorf("%s: got the index", target)
+		}
+	}
+
+	// other paths that aren't canonical are just cleaned
+	if wr := serve(h, http.MethodGet, "//./index.html"); wr.Code != http.StatusOK {
+		t.Errorf("got status %d for a path cleaned into the tree, want %d", wr.Code, http.StatusOK)
+	}
+}
+
+func TestServeIndexMissing(t *testing.T) {
+	rec := &recordingHandler{}
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"}, WithLogger(slog.New(rec)))
+	// a broken state the constructors rule out
+	h.cache.Store(&map[string]cacheEntry{})
+
+	wr := serve(h, http.MethodGet, "/some/route", "Accept", "text/html")
+	if wr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", wr.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(wr.Body.String(), "/index.html") || !strings.HasPrefix(wr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("got %q (%s), want a plain text body naming the index", wr.Body.String(), wr.Header().Get("Content-Type"))
+	}
+
+	record, attrs, ok := rec.find("spa: fallback index is missing")
+	if !ok || record.Level != slog.LevelError {
+		t.Fatal("got no error logged")
+	}
+	if got := attrs["path"].String(); got != "/some/route" {
+		t.Errorf("got path %q, want /some/route", got)
+	}
+	if got := attrs["index"].String(); got != "/index.html" {
+		t.Errorf("got index %q, want /index.html", got)
+	}
+}
+
+func TestNewHandlerMulti(t *testing.T) {
+	dist := writeTree(t, map[string]string{
+		"index.html": "<html>dist</html>",
+		"app.js":     "console.log('dist')",
+		"robots.txt": "User-agent: *",
+	})
+	assets := writeTree(t, map[string]string{
+		"app.js":    "console.log('assets')",
+		"img/a.svg": "<svg></svg>",
+	})
+
+	h, err := NewHandlerMulti([]string{dist, assets}, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for urlpath, want := range map[string]string{
+		"/index.html": "<html>dist</html>",
+		"/robots.txt": "User-agent: *",
+		"/img/a.svg":  "<svg></svg>",
+		// the later directory wins
+		"/app.js": "console.log('assets')",
+	} {
+		if wr := serve(h, http.MethodGet, urlpath); wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %q", urlpath, wr.Code, wr.Body.String(), want)
+		}
+	}
+
+	_, err = NewHandlerMulti([]string{dist, assets}, WithLogger(discardLogger), WithCollisionError())
+	if err == nil || !strings.Contains(err.Error(), "/app.js") {
+		t.Errorf("got error %v, want one naming the colliding /app.js", err)
+	}
+
+	// the index may come from any of them
+	if _, err = NewHandlerMulti([]string{assets, dist}, WithLogger(discardLogger)); err != nil {
+		t.Errorf("index in the last directory: %v", err)
+	}
+	if _, err = NewHandlerMulti([]string{assets}, WithLogger(discardLogger)); err == nil {
+		t.Error("got no error without an index in any directory")
+	}
+}
+
+func TestNewHandlerLayeredFS(t *testing.T) {
+	base := fstest.MapFS{
+		"index.html":     {Data: []byte("<html>default</html>")},
+		"app.js":         {Data: []byte("console.log('default')")},
+		"theme/logo.svg": {Data: []byte("<svg>default</svg>")},
+	}
+	overlay := fstest.MapFS{
+		"index.html":     {Data: []byte("<html>tenant</html>")},
+		"theme/logo.svg": {Data: []byte("<svg>tenant</svg>")},
+		"tenant.html":    {Data: []byte("<html>tenant only</html>")},
+	}
+
+	h, err := NewHandlerLayeredFS([]fs.FS{base, overlay}, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for urlpath, want := range map[string]string{
+		// the overlay wins
+		"/index.html":     "<html>tenant</html>",
+		"/theme/logo.svg": "<svg>tenant</svg>",
+		"/some/route":     "<html>tenant</html>",
+		// and adds to the base
+		"/tenant.html": "<html>tenant only</html>",
+		"/app.js":      "console.log('default')",
+	} {
+		if wr := serve(h, http.MethodGet, urlpath, "Accept", "text/html"); wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %q, want %q", urlpath, wr.Code, wr.Body.String(), want)
+		}
+	}
+	if got := len(h.entries()); got != 4 {
+		t.Errorf("got %d entries, want 4", got)
+	}
+
+	// the index only has to exist in the merged view
+	noIndex := fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}
+	if _, err := NewHandlerLayeredFS([]fs.FS{noIndex, overlay}, WithLogger(discardLogger)); err != nil {
+		t.Errorf("index in the overlay only: %v", err)
+	}
+	if _, err := NewHandlerLayeredFS([]fs.FS{noIndex}, WithLogger(discardLogger)); err == nil {
+		t.Error("got no error without an index in any layer")
+	}
+
+	_, err = NewHandlerLayeredFS([]fs.FS{base, overlay}, WithLogger(discardLogger), WithCollisionError())
+	if err == nil || !strings.Contains(err.Error(), "/index.html") {
+		t.Errorf("got error %v, want one naming the colliding /index.html", err)
+	}
+}
+
+func TestServeSingleContentType(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": compressible})
+
+	for _, acceptEncoding := range []string{"", "gzip", "br"} {
+		wr := httptest.NewRecorder()
+		// as set by some middleware in front of the handler
+		wr.Header().Set("Content-Type", "application/octet-stream")
+		wr.Header().Set("Content-Encoding", "identity")
+
+		r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		h.ServeHTTP(wr, r)
+
+		if got := wr.Header().Values("Content-Type"); !slices.Equal(got, []string{"text/html; charset=utf-8"}) {
+			t.Errorf("%q: got Content-Type %q, want a single text/html", acceptEncoding, got)
+		}
+		if got := wr.Header().Values("Content-Encoding"); acceptEncoding != "" && !slices.Equal(got, []string{acceptEncoding}) {
+			t.Errorf("%q: got Content-Encoding %q, want a single %s", acceptEncoding, got, acceptEncoding)
+		}
+	}
+}
+
+// a [slog.Handler] that calls cancel once n files have been found by the scan
+type cancelAfterFiles struct {
+	recordingHandler
+	n      int
+	cancel context.CancelFunc
+}
+
+func (ch *cancelAfterFiles) Handle(ctx context.Context, r slog.Record) error {
+	if strings.HasPrefix(r.Message, "spa: found file") {
+		if ch.n--; ch.n == 0 {
+			ch.cancel()
+		}
+	}
+	return nil
+}
+
+func TestNewHandlerContextCancelled(t *testing.T) {
+	files := map[string]string{"index.html": "<html></html>"}
+	for i := range 500 {
+		files["assets/"+strconv.Itoa(i%10)+"/"+strconv.Itoa(i)+".js"] = "console.log(" + strconv.Itoa(i) + ")"
+	}
+	dir := writeTree(t, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := slog.New(&cancelAfterFiles{n: 50, cancel: cancel})
+	h, err := NewHandlerContext(ctx, dir, WithLogger(logger))
+	if !errors.Is(err, context.Canceled) || h != nil {
+		t.Errorf("cancelled mid-scan: got %v, want %v and no handler", err, context.Canceled)
+	}
+
+	// and before it starts
+	if _, err := NewHandlerContext(ctx, dir, WithLogger(discardLogger)); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled before: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestServeEmptyFile(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"index.html": "<html></html>",
+		"empty.js":   "",
+	}, WithMinCompressSize(0))
+
+	if entry := h.entries()["/empty.js"]; entry.shouldServeCompressed || entry.gzipHandler != nil || len(entry.saveDataVariants) > 0 {
+		t.Error("empty file has compressed variants")
+	}
+
+	for _, acceptEncoding := range []string{"", "gzip, br"} {
+		wr := serve(h, http.MethodGet, "/empty.js", "Accept-Encoding", acceptEncoding, "Save-Data", "on")
+		if wr.Code != http.StatusOK || wr.Body.Len() != 0 {
+			t.Errorf("%q: got status %d with %d bytes, want %d with none", acceptEncoding, wr.Code, wr.Body.Len(), http.StatusOK)
+		}
+		if got := wr.Header().Get("Content-Length"); got != "0" {
+			t.Errorf("%q: got Content-Length %q, want 0", acceptEncoding, got)
+		}
+		if got := wr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%q: got Content-Encoding %q, want none", acceptEncoding, got)
+		}
+	}
+}
+
+func TestNewHandlerEmptyDir(t *testing.T) {
+	empty := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(empty, "assets", "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewHandlerWithOptions(empty, WithLogger(discardLogger))
+	if err == nil || !strings.Contains(err.Error(), "no files to serve") {
+		t.Errorf("empty: got error %v, want one saying there are no files", err)
+	}
+
+	_, err = NewHandlerWithOptions(writeTree(t, map[string]string{"app.js": ""}), WithLogger(discardLogger))
+	if err == nil || !strings.Contains(err.Error(), "/index.html not found") {
+		t.Errorf("without an index: got error %v, want one saying it isn't found", err)
+	}
+}
+
+func TestNewHandlerArchive(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"assets/", "assets/img/"} {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"index.html":       "<html></html>",
+		"assets/app.js":    compressible,
+		"assets/img/a.svg": "<svg></svg>",
+		".env":             "SECRET=1",
+		"_drafts/post.md":  "draft",
+		"assets/.DS_Store": "junk",
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHandlerArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()), WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for urlpath, want := range map[string]string{
+		"/":                 files["index.html"],
+		"/assets/app.js":    files["assets/app.js"],
+		"/assets/img/a.svg": files["assets/img/a.svg"],
+		"/some/route":       files["index.html"],
+	} {
+		wr := serve(h, http.MethodGet, urlpath, "Accept", "text/html")
+		if wr.Code != http.StatusOK || wr.Body.String() != want {
+			t.Errorf("%s: got status %d with %d bytes, want %d bytes", urlpath, wr.Code, wr.Body.Len(), len(want))
+		}
+	}
+
+	if got := decode(t, "gzip", serve(h, http.MethodGet, "/assets/app.js", "Accept-Encoding", "gzip").Body); got != compressible {
+		t.Error("gzip: got different content")
+	}
+
+	for _, urlpath := range []string{"/assets", "/assets/img", "/.env", "/_drafts/post.md", "/assets/.DS_Store"} {
+		if _, ok := h.entries()[urlpath]; ok {
+			t.Errorf("%s is served", urlpath)
+		}
+	}
+
+	if _, err := NewHandlerArchive(strings.NewReader("not a zip"), 9); err == nil {
+		t.Error("got no error for an invalid archive")
+	}
+}
+
+func TestHandlerAsHTTPHandler(t *testing.T) {
+	dir := writeTree(t, map[string]string{"index.html": "<html></html>"})
+
+	h, err := NewHandler(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// usable wherever an http.Handler is, e.g. mounted on a mux behind middleware
+	var handler http.Handler = h
+	mux := http.NewServeMux()
+	mux.Handle("/app/", http.StripPrefix("/app", handler))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/app/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "<html></html>" {
+		t.Errorf("got status %d with %q, want %d with the index", resp.StatusCode, body, http.StatusOK)
+	}
+
+	// while its methods stay reachable
+	if err := os.WriteFile(filepath.Join(dir, "new.js"), []byte("console.log(1)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.Lookup("/new.js"); !ok {
+		t.Error("/new.js isn't served after Reload")
+	}
+	if got := h.Stats().Entries; got != 2 {
+		t.Errorf("got %d entries, want 2", got)
+	}
+	if got := len(h.Routes()); got != 2 {
+		t.Errorf("got %d routes, want 2", got)
+	}
+}
@@ -0,0 +1,73 @@
+package spa
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appends (and returns) cacheEntrys for the symlink at fpath in root
+// (served at urlpath) to slice, following it as configured.
+// contents maps the etags of the files read so far to their content.
+func appendSymlinkEntries(ctx context.Context, slice []cacheEntry, root scanRoot, urlpath string, fpath string, c *config, contents map[string][]byte) ([]cacheEntry, error) {
+	link := filepath.Join(root.realDir, filepath.FromSlash(fpath))
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return nil, fmt.Errorf("spa: failed to resolve symlink %s: %w", link, err)
+	}
+
+	fi, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("spa: failed to stat %s: %w", target, err)
+	}
+
+	if !c.followSymlinks {
+		if !isWithinDir(root.chain[0], target) {
+			return nil, fmt.Errorf("spa: symlink %s resolves to %s, outside of the served directory (see WithFollowSymlinks)", link, target)
+		}
+
+		if fi.IsDir() {
+			c.logger.Debug(fmt.Sprintf("spa: not following symlinked directory: %s", fpath))
+			return slice, nil
+		}
+
+		return appendFileEntry(slice, urlpath, root.fsys, fpath, c, contents)
+	}
+
+	if !fi.IsDir() {
+		return appendFileEntry(slice, urlpath, root.fsys, fpath, c, contents)
+	}
+
+	// scanning an ancestor of (or the same directory as) anything we're
+	// already scanning would lead us right back to this link
+	linkDir, err := filepath.EvalSymlinks(filepath.Dir(link))
+	if err != nil {
+		return nil, fmt.Errorf("spa: failed to resolve %s: %w", filepath.Dir(link), err)
+	}
+	for _, dir := range append(root.chain, linkDir) {
+		if isWithinDir(target, dir) {
+			c.logger.Warn("spa: skipping symlink that loops", "link", link, "target", target)
+			return slice, nil
+		}
+	}
+
+	c.logger.Debug(fmt.Sprintf("spa: following symlink %s to %s", fpath, target))
+	return appendDirEntries(ctx, slice, scanRoot{
+		fsys:      os.DirFS(target),
+		urlprefix: urlpath,
+		realDir:   target,
+		chain:     append(root.chain[:len(root.chain):len(root.chain)], target),
+	}, c, contents)
+}
+
+// Reports whether fpath is dir or is inside of it (both being real, absolute or not, paths)
+func isWithinDir(dir string, fpath string) bool {
+	rel, err := filepath.Rel(dir, fpath)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
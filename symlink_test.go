@@ -0,0 +1,71 @@
+package spa
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// creates a symlink at name (in dir) pointing to target
+func symlink(t *testing.T, dir string, target string, name string) {
+	t.Helper()
+
+	if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	outside := writeTree(t, map[string]string{"passwd": "root:x:0:0"})
+	dir := writeTree(t, map[string]string{
+		"index.html":      "<html></html>",
+		"assets/app.js":   "console.log(1)",
+		"assets/app.css":  "body{}",
+		"nested/deep.txt": "deep",
+	})
+	symlink(t, dir, filepath.Join("assets", "app.js"), "latest.js")
+	symlink(t, dir, "assets", "static")
+	// an ancestor, which would loop forever
+	symlink(t, dir, "..", "nested/up")
+
+	h, err := NewHandlerWithOptions(dir, WithLogger(discardLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr := serve(h, http.MethodGet, "/latest.js"); wr.Code != http.StatusOK || wr.Body.String() != "console.log(1)" {
+		t.Errorf("in-tree file link: got status %d with %q, want the target", wr.Code, wr.Body.String())
+	}
+	if _, ok := h.entries()["/static/app.css"]; ok {
+		t.Error("directory link followed without WithFollowSymlinks")
+	}
+
+	h, err = NewHandlerWithOptions(dir, WithLogger(discardLogger), WithFollowSymlinks(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr := serve(h, http.MethodGet, "/static/app.css"); wr.Code != http.StatusOK || wr.Body.String() != "body{}" {
+		t.Errorf("in-tree directory link: got status %d with %q, want the target", wr.Code, wr.Body.String())
+	}
+	for urlpath := range h.entries() {
+		if strings.HasPrefix(urlpath, "/nested/up/") {
+			t.Errorf("followed the looping link to %s", urlpath)
+			break
+		}
+	}
+
+	symlink(t, dir, filepath.Join(outside, "passwd"), "passwd")
+	_, err = NewHandlerWithOptions(dir, WithLogger(discardLogger))
+	if err == nil || !strings.Contains(err.Error(), "outside of the served directory") {
+		t.Errorf("escaping link: got error %v, want it rejected", err)
+	}
+
+	h, err = NewHandlerWithOptions(dir, WithLogger(discardLogger), WithFollowSymlinks(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr := serve(h, http.MethodGet, "/passwd"); wr.Body.String() != "root:x:0:0" {
+		t.Errorf("escaping link with WithFollowSymlinks: got %q, want the target", wr.Body.String())
+	}
+}
@@ -0,0 +1,49 @@
+package spa
+
+import (
+	"net/http"
+)
+
+// ConcurrencyMode selects what [WithMaxConcurrentRequests] does with requests
+// beyond the limit
+type ConcurrencyMode int
+
+const (
+	// ConcurrencyReject responds 503 Service Unavailable (with a Retry-After)
+	// right away
+	ConcurrencyReject ConcurrencyMode = iota + 1
+	// ConcurrencyWait waits for a request in flight to finish - responding 503
+	// should the request's context be done first (e.g. the client gave up)
+	ConcurrencyWait
+)
+
+// seconds clients rejected for being over the concurrency limit are told to wait
+const concurrencyRetryAfter = "1"
+
+// acquires one of h's request slots, reporting false (having written the
+// response) if r can't be served now
+func (h *Handler) acquireSlot(wr http.ResponseWriter, r *http.Request) bool {
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if h.config.concurrencyMode == ConcurrencyWait {
+		select {
+		case h.slots <- struct{}{}:
+			return true
+		case <-r.Context().Done():
+		}
+	}
+
+	h.logger.Debug("spa: too many concurrent requests", "path", r.URL.Path)
+	wr.Header().Set("Retry-After", concurrencyRetryAfter)
+	writeError(wr, r, http.StatusServiceUnavailable, h.errorHandler)
+	return false
+}
+
+// releases a request slot acquired by acquireSlot
+func (h *Handler) releaseSlot() {
+	<-h.slots
+}
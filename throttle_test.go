@@ -0,0 +1,117 @@
+package spa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a [http.ResponseWriter] whose first body write signals started, then blocks
+// until release is closed - holding the request in flight
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingWriter(release chan struct{}) *blockingWriter {
+	return &blockingWriter{ResponseRecorder: httptest.NewRecorder(), started: make(chan struct{}), release: release}
+}
+
+func (bw *blockingWriter) Write(bs []byte) (int, error) {
+	bw.once.Do(func() {
+		close(bw.started)
+		<-bw.release
+	})
+	return bw.ResponseRecorder.Write(bs)
+}
+
+// serves n requests with h that stay in flight until the returned func is called
+func holdRequests(t *testing.T, h http.Handler, n int) (release func()) {
+	t.Helper()
+
+	ch := make(chan struct{})
+	var wg sync.WaitGroup
+	for range n {
+		bw := newBlockingWriter(ch)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(bw, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+		}()
+
+		select {
+		case <-bw.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("request didn't start")
+		}
+	}
+
+	return func() {
+		close(ch)
+		wg.Wait()
+	}
+}
+
+func TestMaxConcurrentRequestsReject(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"},
+		WithMaxConcurrentRequests(2, ConcurrencyReject), WithHealthPath("/healthz"))
+
+	release := holdRequests(t, h, 2)
+
+	wr := serve(h, http.MethodGet, "/index.html")
+	if wr.Code != http.StatusServiceUnavailable || wr.Header().Get("Retry-After") != "1" {
+		t.Errorf("over the limit: got status %d with Retry-After %q, want %d with 1", wr.Code, wr.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+
+	// health checks aren't limited
+	if wr := serve(h, http.MethodGet, "/healthz"); wr.Code != http.StatusOK {
+		t.Errorf("health check: got status %d, want %d", wr.Code, http.StatusOK)
+	}
+
+	release()
+	if wr := serve(h, http.MethodGet, "/index.html"); wr.Code != http.StatusOK {
+		t.Errorf("after release: got status %d, want %d", wr.Code, http.StatusOK)
+	}
+}
+
+func TestMaxConcurrentRequestsWait(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"index.html": "<html></html>"}, WithMaxConcurrentRequests(2, ConcurrencyWait))
+
+	release := holdRequests(t, h, 2)
+
+	// queued until a slot frees up
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(h, http.MethodGet, "/index.html")
+	}()
+
+	select {
+	case wr := <-done:
+		t.Fatalf("over the limit: got status %d without waiting", wr.Code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// or until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	wr := httptest.NewRecorder()
+	h.ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/index.html", nil).WithContext(ctx))
+	if wr.Code != http.StatusServiceUnavailable || wr.Header().Get("Retry-After") != "1" {
+		t.Errorf("past the deadline: got status %d with Retry-After %q, want %d with 1", wr.Code, wr.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+
+	release()
+	select {
+	case wr := <-done:
+		if wr.Code != http.StatusOK {
+			t.Errorf("queued: got status %d, want %d", wr.Code, http.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued request wasn't served after release")
+	}
+}
@@ -0,0 +1,122 @@
+package spa
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long the source directory must be quiet before a change triggers a reload,
+// so that e.g. a build writing many files causes only one rescan
+const watchDebounce = 200 * time.Millisecond
+
+// WithWatch watches the source directories (recursively) and reloads the handler
+// whenever their contents change, until ctx is done. Bursts of changes are
+// debounced into a single reload.
+//
+// It is only supported for handlers serving directories (e.g. [NewHandlerWithOptions]).
+// A failed reload is logged and the previous content keeps being served.
+func WithWatch(ctx context.Context) Option {
+	return func(c *config) {
+		c.watchCtx = ctx
+	}
+}
+
+// starts watching dirs and reloading h on change until ctx is done
+func (h *Handler) watch(ctx context.Context, dirs []string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("spa: failed to create watcher: %w", err)
+	}
+
+	for _, dir := range dirs {
+		err = addWatchTree(w, dir)
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	// Close stops the watcher too
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	h.stopWatching = func() {
+		cancel()
+		<-done
+	}
+
+	go h.runWatcher(ctx, w, done)
+	return nil
+}
+
+// handles events from w until ctx is done, then closes w and done
+func (h *Handler) runWatcher(ctx context.Context, w *fsnotify.Watcher, done chan<- struct{}) {
+	defer close(done)
+	defer w.Close()
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.logger.Debug("spa: stopped watching")
+			return
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			h.logger.Debug(fmt.Sprintf("spa: change detected: %s", ev))
+
+			// new directories aren't watched automatically
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := addWatchTree(w, ev.Name); err != nil {
+						h.logger.Warn("spa: failed to watch new directory", "dir", ev.Name, "err", err)
+					}
+				}
+			}
+
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			h.logger.Warn("spa: watcher error", "err", err)
+
+		case <-timer.C:
+			if err := h.Reload(); err != nil {
+				h.logger.Error("spa: reload failed", "err", err)
+				continue
+			}
+			h.logger.Info("spa: reloaded")
+		}
+	}
+}
+
+// adds dir and every directory below it to w
+func addWatchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("spa: failed to read directory %s: %w", fpath, err)
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if err := w.Add(fpath); err != nil {
+			return fmt.Errorf("spa: failed to watch %s: %w", fpath, err)
+		}
+
+		return nil
+	})
+}
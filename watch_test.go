@@ -0,0 +1,93 @@
+package spa
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waits for h to serve want at urlpath, failing t if it doesn't within timeout
+func waitForContent(t *testing.T, h http.Handler, urlpath string, want string, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		wr := serve(h, http.MethodGet, urlpath, "Accept", "*/*")
+		if wr.Code == http.StatusOK && wr.Body.String() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got status %d with %q after %v, want %q", urlpath, wr.Code, wr.Body.String(), timeout, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := writeTree(t, map[string]string{"index.html": "<html></html>"})
+	h, err := NewHandlerWithOptions(dir, WithLogger(discardLogger), WithWatch(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// the debounce window, with some slack for a slow machine
+	timeout := watchDebounce + time.Second
+
+	if err := os.WriteFile(filepath.Join(dir, "new.js"), []byte("console.log(1)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForContent(t, h, "/new.js", "console.log(1)", timeout)
+
+	// including in directories created since the handler was built
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// past the reload for the directory itself, so only a watch on it sees the file
+	time.Sleep(2 * watchDebounce)
+	if err := os.WriteFile(filepath.Join(dir, "assets", "app.css"), []byte("body{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForContent(t, h, "/assets/app.css", "body{}", timeout)
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	dir := writeTree(t, map[string]string{"index.html": "<html></html>", "app.js": compressible})
+	h, err := NewHandlerWithOptions(dir, WithLogger(discardLogger), WithWatch(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("watching started no goroutines")
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	// those that fsnotify starts may take a moment to notice they're done
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d goroutines after Close, want at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// it keeps serving what it had
+	if wr := serve(h, http.MethodGet, "/app.js"); wr.Code != http.StatusOK || wr.Body.String() != compressible {
+		t.Errorf("got status %d after Close, want %d", wr.Code, http.StatusOK)
+	}
+}